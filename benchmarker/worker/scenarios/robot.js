@@ -15,7 +15,7 @@ const bench_robot_updateOrdersStatus_fail_count = new Counter(
 // テストシナリオ
 export function robotAPIScenario() {
   const headers = {
-    "X-API-KEY": "test-robot-key",
+    "X-API-KEY": __ENV.ROBOT_API_KEY || "test-robot-key",
     "Content-Type": "application/json",
   };
 
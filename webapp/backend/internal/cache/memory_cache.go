@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -46,8 +47,7 @@ func (c *MemoryCache) Get(key string) (interface{}, bool) {
 	}
 	
 	if time.Now().After(item.ExpiresAt) {
-		// 期限切れ
-		delete(c.items, key)
+		// 期限切れ（読み取りロック中は削除せず、定期削除に任せる）
 		return nil, false
 	}
 	
@@ -61,6 +61,43 @@ func (c *MemoryCache) Delete(key string) {
 	delete(c.items, key)
 }
 
+// キーが存在しない（または期限切れの）場合のみセットし、セットしたかを返す
+// 確認と登録を1つのロックの中で行うため、同じキーを同時にセットしても成功するのは1つだけ
+func (c *MemoryCache) SetIfAbsent(key string, value interface{}, duration time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if item, exists := c.items[key]; exists && !now.After(item.ExpiresAt) {
+		return false
+	}
+	c.items[key] = &CacheItem{
+		Value:     value,
+		ExpiresAt: now.Add(duration),
+	}
+	return true
+}
+
+// キーが prefix で始まるアイテムをまとめて削除
+func (c *MemoryCache) DeletePrefix(prefix string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.items, key)
+		}
+	}
+}
+
+// 全てのアイテムを削除
+func (c *MemoryCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.items = make(map[string]*CacheItem)
+}
+
 // 期限切れアイテムの定期削除
 func (c *MemoryCache) cleanupExpiredItems() {
 	ticker := time.NewTicker(30 * time.Second)
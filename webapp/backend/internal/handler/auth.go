@@ -4,21 +4,26 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
 )
 
 type AuthHandler struct {
-	AuthSvc *service.AuthService
+	AuthSvc        *service.AuthService
+	Cookie         CookieConfig
+	TrustedProxies TrustedProxies
 }
 
-func NewAuthHandler(authSvc *service.AuthService) *AuthHandler {
-	return &AuthHandler{AuthSvc: authSvc}
+func NewAuthHandler(authSvc *service.AuthService, cookie CookieConfig, trustedProxies TrustedProxies) *AuthHandler {
+	return &AuthHandler{AuthSvc: authSvc, Cookie: cookie, TrustedProxies: trustedProxies}
 }
 
-// ログイン時にセッションを発行し、Cookieにセットする
+// ログイン時にセッションとCSRFトークンを発行し、Cookieにセットする
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	log.Println("-> Received request for /api/login")
 
@@ -28,9 +33,13 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password)
+	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password, h.TrustedProxies.clientIP(r))
 	if err != nil {
-		if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
+		var locked *service.LoginLockedError
+		if errors.As(err, &locked) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		} else if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
 			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -38,15 +47,207 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Path:     "/",
-	})
+	csrfToken, err := middleware.NewCSRFToken()
+	if err != nil {
+		log.Printf("Failed to generate CSRF token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookies(w, h.Cookie, sessionID, csrfToken, expiresAt)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
 }
+
+// セッションを削除し、Cookieを破棄する
+// セッションが既に無効でもCookieは破棄して成功を返す
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie("session_id"); err == nil && cookie.Value != "" {
+		if err := h.AuthSvc.Logout(r.Context(), cookie.Value); err != nil {
+			log.Printf("Failed to delete session: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	clearSessionCookies(w, h.Cookie)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logout successful"})
+}
+
+// ログイン中のユーザーの全セッション（他の端末を含む）を削除する
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := h.AuthSvc.RevokeUserSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %d: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookies(w, h.Cookie)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":          "Logged out from all devices",
+		"revoked_sessions": revoked,
+	})
+}
+
+// ユーザーを登録する
+func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var req model.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.AuthSvc.Register(r.Context(), req.UserName, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidUserName):
+			http.Error(w, "Invalid user name: use 3-64 letters, digits, '.', '_' or '-'", http.StatusBadRequest)
+		case errors.Is(err, service.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrUserNameTaken):
+			http.Error(w, "User name is already taken", http.StatusConflict)
+		default:
+			log.Printf("Failed to register user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// ログイン中のユーザー情報を取得
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.AuthSvc.GetUser(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get user %d: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// パスワードを変更し、現在のセッション以外をログアウトさせる
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	currentSessionID := ""
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		currentSessionID = cookie.Value
+	}
+
+	err := h.AuthSvc.ChangePassword(r.Context(), userID, currentSessionID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidPassword):
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		case errors.Is(err, service.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to change password for user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed"})
+}
+
+// APIクライアント向けのログイン
+// Cookieの代わりにアクセストークンとリフレッシュトークンを返す
+func (h *AuthHandler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	var req model.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.AuthSvc.IssueTokens(r.Context(), req.UserName, req.Password, h.TrustedProxies.clientIP(r))
+	if err != nil {
+		var locked *service.LoginLockedError
+		if errors.As(err, &locked) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		} else if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
+			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeTokenResponse(w, tokens)
+}
+
+// リフレッシュトークンを新しいトークンの組と交換する
+// 使ったリフレッシュトークンは無効になる
+func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req model.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.AuthSvc.RefreshTokens(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			http.Error(w, "Unauthorized: Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Failed to refresh tokens: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeTokenResponse(w, tokens)
+}
+
+func writeTokenResponse(w http.ResponseWriter, tokens *model.TokenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// X-Real-IPを信頼するリバースプロキシのアドレス範囲
+type TrustedProxies []*net.IPNet
+
+// 信頼するプロキシを環境変数 TRUSTED_PROXIES（カンマ区切りのCIDRまたはIPアドレス）から取得
+// 未設定の場合はどの接続元も信頼せず、X-Real-IPは常に無視する
+func GetTrustedProxies() TrustedProxies {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+				continue
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			bits := 8 * len(ip)
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			continue
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies
+}
+
+func (p TrustedProxies) contains(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// リクエスト元のIPアドレス
+// 接続元が信頼するプロキシ(nginx)の場合のみ、プロキシが付与するX-Real-IPを使う
+// それ以外の接続元からのX-Real-IPは偽装できるため無視し、接続元アドレスを使う
+func (p TrustedProxies) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if p.contains(host) {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	return host
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPHonorsXRealIPOnlyFromTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "172.16.0.0/12, 10.0.0.5, not-an-ip")
+	proxies := GetTrustedProxies()
+	if len(proxies) != 2 {
+		t.Fatalf("parsed %d proxies, want 2", len(proxies))
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{name: "trusted proxy", remoteAddr: "172.18.0.3:51234", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted single address", remoteAddr: "10.0.0.5:40000", realIP: "203.0.113.8", want: "203.0.113.8"},
+		{name: "spoofed header from client", remoteAddr: "198.51.100.20:40000", realIP: "203.0.113.9", want: "198.51.100.20"},
+		{name: "trusted proxy without header", remoteAddr: "172.18.0.3:51234", realIP: "", want: "172.18.0.3"},
+		{name: "trusted proxy with malformed header", remoteAddr: "172.18.0.3:51234", realIP: "evil", want: "172.18.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/login", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := proxies.clientIP(req); got != tt.want {
+				t.Fatalf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPIgnoresXRealIPWithoutTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	req := httptest.NewRequest("POST", "/api/login", nil)
+	req.RemoteAddr = "172.18.0.3:51234"
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+	if got := GetTrustedProxies().clientIP(req); got != "172.18.0.3" {
+		t.Fatalf("clientIP = %q, want %q", got, "172.18.0.3")
+	}
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/middleware"
+)
+
+// セッション・CSRFトークンのCookie属性
+type CookieConfig struct {
+	SameSite http.SameSite
+	Secure   bool
+}
+
+// Cookie属性を環境変数から取得
+// SESSION_COOKIE_SECURE が未設定の場合は secureByDefault を使う
+func GetCookieConfig(secureByDefault bool) CookieConfig {
+	// デフォルト値
+	cfg := CookieConfig{SameSite: http.SameSiteLaxMode, Secure: secureByDefault}
+
+	switch strings.ToLower(os.Getenv("SESSION_COOKIE_SAMESITE")) {
+	case "strict":
+		cfg.SameSite = http.SameSiteStrictMode
+	case "lax":
+		cfg.SameSite = http.SameSiteLaxMode
+	case "none":
+		cfg.SameSite = http.SameSiteNoneMode
+	}
+
+	if val := os.Getenv("SESSION_COOKIE_SECURE"); val != "" {
+		cfg.Secure = strings.EqualFold(val, "true")
+	}
+
+	// SameSite=None はSecureなCookieでなければブラウザに拒否される
+	if cfg.SameSite == http.SameSiteNoneMode {
+		cfg.Secure = true
+	}
+	return cfg
+}
+
+// セッションIDとCSRFトークンのCookieをセットする
+// CSRFトークンはフロントエンドがヘッダーに載せられるようHttpOnlyにしない
+func setSessionCookies(w http.ResponseWriter, cfg CookieConfig, sessionID, csrfToken string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    sessionID,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   cfg.Secure,
+		SameSite: cfg.SameSite,
+		Path:     "/",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookieName,
+		Value:    csrfToken,
+		Expires:  expiresAt,
+		Secure:   cfg.Secure,
+		SameSite: cfg.SameSite,
+		Path:     "/",
+	})
+}
+
+// セッションIDとCSRFトークンのCookieを即時に失効させる
+func clearSessionCookies(w http.ResponseWriter, cfg CookieConfig) {
+	for _, c := range []struct {
+		name     string
+		httpOnly bool
+	}{
+		{"session_id", true},
+		{middleware.CSRFCookieName, false},
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.name,
+			Value:    "",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: c.httpOnly,
+			Secure:   cfg.Secure,
+			SameSite: cfg.SameSite,
+			Path:     "/",
+		})
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"backend/internal/model"
 	"backend/internal/service"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type OrderHandler struct {
@@ -66,3 +70,95 @@ func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// 購入の明細を取得
+func (h *OrderHandler) GetCheckout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	checkoutID, err := strconv.ParseInt(chi.URLParam(r, "checkoutID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid checkout ID", http.StatusBadRequest)
+		return
+	}
+
+	checkout, err := h.OrderSvc.GetCheckout(r.Context(), userID, checkoutID)
+	if err != nil {
+		if errors.Is(err, service.ErrCheckoutNotFound) {
+			http.Error(w, "Checkout not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get checkout %d for user %d: %v", checkoutID, userID, err)
+		http.Error(w, "Failed to get checkout", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkout)
+}
+
+// 注文の詳細を取得
+func (h *OrderHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "orderID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.OrderSvc.GetOrder(r.Context(), userID, orderID)
+	if err != nil {
+		if errors.Is(err, service.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get order %d for user %d: %v", orderID, userID, err)
+		http.Error(w, "Failed to get order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// 注文をキャンセル
+func (h *OrderHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "orderID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.OrderSvc.CancelOrder(r.Context(), userID, orderID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderNotCancellable):
+			http.Error(w, "Order can only be cancelled while it is shipping", http.StatusConflict)
+		default:
+			log.Printf("Failed to cancel order %d for user %d: %v", orderID, userID, err)
+			http.Error(w, "Failed to cancel order", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order_id":       orderID,
+		"shipped_status": service.OrderStatusCancelled,
+	})
+}
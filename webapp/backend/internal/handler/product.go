@@ -5,6 +5,7 @@ import (
 	"backend/internal/model"
 	"backend/internal/service"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,6 +14,12 @@ import (
 	"strings"
 )
 
+// 注文作成の冪等キーを指定するヘッダー
+const (
+	IdempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
 type ProductHandler struct {
 	ProductSvc *service.ProductService
 }
@@ -82,7 +89,38 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	insertedOrderIDs, err := h.ProductSvc.CreateOrders(r.Context(), userID, req.Items)
+	// 再送で注文が重複しないよう、クライアントが指定した冪等キーで結果を記録する
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+
+	checkout, insertedOrderIDs, err := h.ProductSvc.CreateOrders(r.Context(), userID, idempotencyKey, req.Items)
+	if errors.Is(err, service.ErrIdempotencyKeyReused) {
+		http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	var unknownErr *service.UnknownProductsError
+	if errors.As(err, &unknownErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":     "unknown product",
+			"product_ids": unknownErr.ProductIDs,
+		})
+		return
+	}
+	var stockErr *service.InsufficientStockError
+	if errors.As(err, &stockErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "insufficient stock",
+			"items":   stockErr.Items,
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to create orders: %v", err)
 		http.Error(w, "Failed to process order request", http.StatusInternalServerError)
@@ -93,6 +131,10 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 		"message":   "Orders created successfully",
 		"order_ids": insertedOrderIDs,
 	}
+	if checkout != nil {
+		response["checkout_id"] = checkout.CheckoutID
+		response["total_value"] = checkout.TotalValue
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(response)
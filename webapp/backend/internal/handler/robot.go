@@ -1,12 +1,18 @@
 package handler
 
 import (
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type RobotHandler struct {
@@ -18,23 +24,33 @@ func NewRobotHandler(robotSvc *service.RobotService) *RobotHandler {
 }
 
 // 配送計画を取得
+// capacity(重量)・volume_capacity(容積)・max_items(個数)を省略した場合はロボット台帳に登録された値を使う
 func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
-	robotID := "robot-001"
+	robot, ok := middleware.GetRobotFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Robot not found in context", http.StatusInternalServerError)
+		return
+	}
 
-	capacityStr := r.URL.Query().Get("capacity")
-	if capacityStr == "" {
-		http.Error(w, "Query parameter 'capacity' is required", http.StatusBadRequest)
+	capacity, err := parseDeliveryCapacity(r, robot)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	capacity, err := strconv.Atoi(capacityStr)
+
+	opts, err := parseDeliveryPlanOptions(r)
 	if err != nil {
-		http.Error(w, "Query parameter 'capacity' must be an integer", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	plan, err := h.RobotSvc.GenerateDeliveryPlan(r.Context(), robotID, capacity)
+	plan, err := h.RobotSvc.GenerateDeliveryPlan(r.Context(), robot.RobotID, capacity, opts)
 	if err != nil {
 		log.Printf("Failed to generate delivery plan: %v", err)
+		if errors.Is(err, service.ErrPlanConflict) {
+			http.Error(w, "Delivery plan conflicted with other robots, please retry", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to create delivery plan", http.StatusInternalServerError)
 		return
 	}
@@ -43,21 +59,348 @@ func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(plan)
 }
 
+// 注文を割り当てずに配送計画をプレビュー
+// robot_idを指定すると、そのロボットの登録済み積載量で計画した場合の結果を返す
+func (h *RobotHandler) PreviewDeliveryPlan(w http.ResponseWriter, r *http.Request) {
+	robot, ok := middleware.GetRobotFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Robot not found in context", http.StatusInternalServerError)
+		return
+	}
+	if robotID := r.URL.Query().Get("robot_id"); robotID != "" && robotID != robot.RobotID {
+		var err error
+		robot, err = h.RobotSvc.GetRobot(r.Context(), robotID)
+		if err != nil {
+			if errors.Is(err, service.ErrRobotNotFound) {
+				http.Error(w, "Robot not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to find robot %s: %v", robotID, err)
+			http.Error(w, "Failed to preview delivery plan", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	capacity, err := parseDeliveryCapacity(r, robot)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := parseDeliveryPlanOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := h.RobotSvc.PreviewDeliveryPlan(r.Context(), robot.RobotID, capacity, opts)
+	if err != nil {
+		log.Printf("Failed to preview delivery plan: %v", err)
+		http.Error(w, "Failed to preview delivery plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// 積載制限をクエリパラメータから取得し、省略された値はロボット台帳の値で補う
+// 容積と個数は 0 以下なら制限なし(-1)として扱う
+func parseDeliveryCapacity(r *http.Request, robot *model.Robot) (model.DeliveryCapacity, error) {
+	q := r.URL.Query()
+	capacity := model.DeliveryCapacity{
+		Weight: robot.Capacity,
+		Volume: robot.VolumeCapacity,
+		Items:  robot.MaxItems,
+	}
+	if q.Get("capacity") == "" && capacity.Weight <= 0 {
+		return capacity, errors.New("Query parameter 'capacity' is required")
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{
+		{"capacity", &capacity.Weight},
+		{"volume_capacity", &capacity.Volume},
+		{"max_items", &capacity.Items},
+	} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return capacity, fmt.Errorf("Query parameter '%s' must be an integer", p.name)
+			}
+			*p.dst = n
+		}
+	}
+	if capacity.Volume <= 0 {
+		capacity.Volume = -1
+	}
+	if capacity.Items <= 0 {
+		capacity.Items = -1
+	}
+	return capacity, nil
+}
+
+// 配送計画の選択方針をクエリパラメータから取得
+// objective: value(既定) または age、age_bonus_per_minute: 待ち時間1分あたりのボーナス、
+// must_include_after_minutes: この分数より長く待っている注文を必ず含める
+func parseDeliveryPlanOptions(r *http.Request) (model.DeliveryPlanOptions, error) {
+	q := r.URL.Query()
+	opts := model.DeliveryPlanOptions{Objective: q.Get("objective")}
+
+	if v := q.Get("age_bonus_per_minute"); v != "" {
+		bonus, err := strconv.Atoi(v)
+		if err != nil || bonus < 0 {
+			return opts, errors.New("Query parameter 'age_bonus_per_minute' must be a non-negative integer")
+		}
+		opts.AgeBonusPerMinute = bonus
+	}
+	if v := q.Get("must_include_after_minutes"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			return opts, errors.New("Query parameter 'must_include_after_minutes' must be a non-negative integer")
+		}
+		opts.MustIncludeAfter = time.Duration(minutes) * time.Minute
+	}
+
+	opts, err := service.NormalizeDeliveryPlanOptions(opts)
+	if err != nil {
+		return opts, errors.New("Query parameter 'objective' must be one of 'value' or 'age'")
+	}
+	return opts, nil
+}
+
 // 配送完了時に注文ステータスを更新
 func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	robot, ok := middleware.GetRobotFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Robot not found in context", http.StatusInternalServerError)
+		return
+	}
+
 	var req model.UpdateOrderStatusRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	err := h.RobotSvc.UpdateOrderStatus(r.Context(), req.OrderID, req.NewStatus)
+	err := h.RobotSvc.UpdateOrderStatus(r.Context(), robot.RobotID, req.OrderID, req.NewStatus)
 	if err != nil {
 		log.Printf("Failed to update order status for order %d: %v", req.OrderID, err)
-		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, service.ErrInvalidOrderStatus):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderNotAssigned):
+			http.Error(w, "Order is not assigned to this robot", http.StatusForbidden)
+		case errors.Is(err, service.ErrIllegalOrderTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		}
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Order status updated"))
 }
+
+// 1回のリクエストで受け付ける一括ステータス更新の上限
+const maxBatchStatusUpdates = 1000
+
+// 複数の注文ステータスを一括で更新し、注文ごとの結果を返す
+func (h *RobotHandler) UpdateOrderStatuses(w http.ResponseWriter, r *http.Request) {
+	robot, ok := middleware.GetRobotFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Robot not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.BatchUpdateOrderStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Updates) == 0 {
+		http.Error(w, "Field 'updates' must not be empty", http.StatusBadRequest)
+		return
+	}
+	if len(req.Updates) > maxBatchStatusUpdates {
+		http.Error(w, fmt.Sprintf("Field 'updates' must not exceed %d items", maxBatchStatusUpdates), http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.RobotSvc.UpdateOrderStatuses(r.Context(), robot.RobotID, req.Updates)
+	if err != nil {
+		log.Printf("Failed to batch update order statuses for robot %s: %v", robot.RobotID, err)
+		http.Error(w, "Failed to update order statuses", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Results []model.OrderStatusUpdateResult `json:"results"`
+	}{
+		Results: results,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ロボットが保持している注文の配送リースを延長
+// order_idsを省略した場合は保持している全ての注文が対象
+func (h *RobotHandler) ExtendLease(w http.ResponseWriter, r *http.Request) {
+	robot, ok := middleware.GetRobotFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Robot not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.ExtendLeaseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	extended, leaseExpiresAt, err := h.RobotSvc.ExtendLease(r.Context(), robot.RobotID, req.OrderIDs)
+	if err != nil {
+		log.Printf("Failed to extend delivery lease for robot %s: %v", robot.RobotID, err)
+		http.Error(w, "Failed to extend delivery lease", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"extended":         extended,
+		"lease_expires_at": leaseExpiresAt,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 発行済みの配送計画の履歴を取得
+// robot_idで絞り込み、page・page_sizeでページングする
+// ロボットからの参照は自身の計画のみで、全ロボットの計画は管理者向けのルートから参照する
+func (h *RobotHandler) ListDeliveryPlans(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	robotID := q.Get("robot_id")
+	if robot, ok := middleware.GetRobotFromContext(r.Context()); ok {
+		if robotID != "" && robotID != robot.RobotID {
+			http.Error(w, "Robots can only list their own delivery plans", http.StatusForbidden)
+			return
+		}
+		robotID = robot.RobotID
+	}
+	req := model.ListRequest{Page: 1, PageSize: 20}
+	if v := q.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page <= 0 {
+			http.Error(w, "Query parameter 'page' must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		req.Page = page
+	}
+	if v := q.Get("page_size"); v != "" {
+		pageSize, err := strconv.Atoi(v)
+		if err != nil || pageSize <= 0 {
+			http.Error(w, "Query parameter 'page_size' must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		req.PageSize = pageSize
+	}
+	req.Offset = (req.Page - 1) * req.PageSize
+
+	plans, total, err := h.RobotSvc.ListDeliveryPlans(r.Context(), robotID, req)
+	if err != nil {
+		log.Printf("Failed to list delivery plans: %v", err)
+		http.Error(w, "Failed to list delivery plans", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Data  []model.DeliveryPlanRecord `json:"data"`
+		Total int                        `json:"total"`
+	}{
+		Data:  plans,
+		Total: total,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 配送計画の履歴を1件取得（含まれる注文IDを含む）
+// ロボットからの参照では他のロボットの計画は存在しないものとして扱う
+func (h *RobotHandler) GetDeliveryPlanRecord(w http.ResponseWriter, r *http.Request) {
+	planID, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := h.RobotSvc.GetDeliveryPlanRecord(r.Context(), planID)
+	if err != nil {
+		if errors.Is(err, service.ErrDeliveryPlanNotFound) {
+			http.Error(w, "Delivery plan not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get delivery plan %d: %v", planID, err)
+		http.Error(w, "Failed to get delivery plan", http.StatusInternalServerError)
+		return
+	}
+	if robot, ok := middleware.GetRobotFromContext(r.Context()); ok && plan.RobotID != robot.RobotID {
+		http.Error(w, "Delivery plan not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// 登録済みロボットの一覧を取得
+func (h *RobotHandler) ListRobots(w http.ResponseWriter, r *http.Request) {
+	robots, err := h.RobotSvc.ListRobots(r.Context())
+	if err != nil {
+		log.Printf("Failed to list robots: %v", err)
+		http.Error(w, "Failed to list robots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(robots)
+}
+
+// 認証中のロボット自身のAPIキーをローテーションする
+// 新しいキーはこのレスポンスでのみ返し、旧キーは猶予期間の後に使えなくなる
+func (h *RobotHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	robot, ok := middleware.GetRobotFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Robot not found in context", http.StatusInternalServerError)
+		return
+	}
+	h.rotateAPIKey(w, r, robot.RobotID)
+}
+
+// 指定したロボットのAPIキーをローテーションする（管理者用）
+func (h *RobotHandler) RotateRobotAPIKey(w http.ResponseWriter, r *http.Request) {
+	h.rotateAPIKey(w, r, chi.URLParam(r, "robotID"))
+}
+
+func (h *RobotHandler) rotateAPIKey(w http.ResponseWriter, r *http.Request, robotID string) {
+	rotated, err := h.RobotSvc.RotateAPIKey(r.Context(), robotID)
+	if err != nil {
+		if errors.Is(err, service.ErrRobotNotFound) {
+			http.Error(w, "Robot not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to rotate API key for robot %s: %v", robotID, err)
+		http.Error(w, "Failed to rotate API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rotated)
+}
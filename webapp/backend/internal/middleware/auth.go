@@ -2,19 +2,55 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"backend/internal/cache"
+	"backend/internal/model"
 	"backend/internal/repository"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey       contextKey = "user"
+	roleContextKey       contextKey = "role"
+	bearerAuthContextKey contextKey = "bearer"
+	robotContextKey      contextKey = "robot"
+)
+
+// 共有APIキー(ROBOT_API_KEY)で認証されたロボットに割り当てるID
+const DefaultRobotID = "robot-001"
+
+// アクセストークンを検証する（service.AuthServiceが実装する）
+type AccessTokenVerifier interface {
+	VerifyAccessToken(token string) (*model.SessionUser, error)
+}
 
-func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Handler) http.Handler {
+// Authorization: Bearer のアクセストークン、または session_id Cookieからユーザーを特定し、コンテキストにセットする
+func UserAuthMiddleware(sessionRepo *repository.SessionRepository, tokenVerifier AccessTokenVerifier) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := bearerToken(r); ok {
+				user, err := tokenVerifier.VerifyAccessToken(token)
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, "Unauthorized: Invalid access token", http.StatusUnauthorized)
+					return
+				}
+				ctx := context.WithValue(r.Context(), userContextKey, user.UserID)
+				ctx = context.WithValue(ctx, roleContextKey, user.Role)
+				ctx = context.WithValue(ctx, bearerAuthContextKey, true)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			cookie, err := r.Cookie("session_id")
 			if err != nil {
 				log.Printf("Error retrieving session cookie: %v", err)
@@ -23,36 +59,172 @@ func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Han
 			}
 			sessionID := cookie.Value
 
-			userID, err := sessionRepo.FindUserBySessionID(sessionID)
+			user, err := sessionRepo.FindUserBySessionID(r.Context(), sessionID)
+			if errors.Is(err, repository.ErrSessionNotFound) {
+				http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
+				return
+			}
 			if err != nil {
 				log.Printf("Error finding user by session ID: %v", err)
-				http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), userContextKey, userID)
+			ctx := context.WithValue(r.Context(), userContextKey, user.UserID)
+			ctx = context.WithValue(ctx, roleContextKey, user.Role)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func RobotAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
+// Authorization ヘッダーからBearerトークンを取り出す
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// アクセストークンで認証されたリクエストか
+// Cookieを使わないためCSRFの対象にならない
+func isBearerAuthenticated(ctx context.Context) bool {
+	ok, _ := ctx.Value(bearerAuthContextKey).(bool)
+	return ok
+}
+
+// ロボットの last_seen_at を更新する最短の間隔
+// last_seen_at はこの間隔の分だけ実際の最終アクセスより古くなりうる
+const lastSeenUpdateInterval = 30 * time.Second
+
+// X-API-KEY、または署名付きリクエストの X-API-KEY-ID からロボットを特定し、コンテキストにセットする
+// 台帳にないキーでも共有APIキーと一致すればDefaultRobotIDとして扱う
+func RobotAuthMiddleware(robotRepo *repository.RobotRepository, cfg RobotAuthConfig) func(http.Handler) http.Handler {
+	// 受け付けた署名（再送の検出用）
+	seenSignatures := cache.NewMemoryCache()
+	// last_seen_at を最後に更新したロボット（更新の間引き用）
+	touchedRobots := cache.NewMemoryCache()
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			apiKey := r.Header.Get("X-API-KEY")
-
-			if apiKey == "" || apiKey != validAPIKey {
-				http.Error(w, "Forbidden: Invalid or missing API key", http.StatusForbidden)
+			var (
+				robot *model.Robot
+				err   error
+			)
+			if isSignedRobotRequest(r) {
+				robot, err = authenticateSignedRobot(r, robotRepo, cfg, seenSignatures)
+			} else if cfg.RequireSignature {
+				err = errSignatureRequired
+			} else {
+				robot, err = authenticateRobotAPIKey(r, robotRepo, cfg)
+			}
+			if err != nil {
+				switch {
+				case errors.Is(err, sql.ErrNoRows):
+					http.Error(w, "Forbidden: Invalid or missing API key", http.StatusForbidden)
+				case errors.Is(err, errSignatureRequired), errors.Is(err, errInvalidSignature),
+					errors.Is(err, errStaleTimestamp), errors.Is(err, errReplayedRequest):
+					http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				case errors.Is(err, errSignedBodyTooLarge):
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				default:
+					log.Printf("Error authenticating robot: %v", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+				}
 				return
 			}
-			next.ServeHTTP(w, r)
+
+			// ポーリングのたびに robots の行を更新しないよう、ロボットごとに一定間隔で間引く
+			if touchedRobots.SetIfAbsent(robot.RobotID, struct{}{}, lastSeenUpdateInterval) {
+				if err := robotRepo.TouchLastSeen(r.Context(), robot.RobotID); err != nil {
+					log.Printf("Failed to update last_seen_at for robot %s: %v", robot.RobotID, err)
+					touchedRobots.Delete(robot.RobotID)
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), robotContextKey, robot)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// X-API-KEY の平文キーでロボットを特定する
+func authenticateRobotAPIKey(r *http.Request, robotRepo *repository.RobotRepository, cfg RobotAuthConfig) (*model.Robot, error) {
+	apiKey := r.Header.Get("X-API-KEY")
+	if apiKey == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	robot, err := robotRepo.FindByAPIKey(r.Context(), apiKey)
+	if errors.Is(err, sql.ErrNoRows) && cfg.SharedAPIKey != "" &&
+		subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.SharedAPIKey)) == 1 {
+		robot, err = robotRepo.FindByID(r.Context(), DefaultRobotID)
+	}
+	return robot, err
+}
+
+// 署名付きリクエストのキーIDと署名を検証してロボットを特定する
+func authenticateSignedRobot(r *http.Request, robotRepo *repository.RobotRepository, cfg RobotAuthConfig, seen *cache.MemoryCache) (*model.Robot, error) {
+	keyID, err := signedRobotKeyID(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := robotRepo.FindActiveAPIKey(r.Context(), keyID)
+	if err != nil {
+		return nil, err
+	}
+	// ローテーション前から残るキーには署名用の鍵がないため、署名付きリクエストには使えない
+	if key.SigningSecretEnc == nil {
+		return nil, fmt.Errorf("%w: key %d has no signing secret, rotate it first", errInvalidSignature, keyID)
+	}
+	signingSecret, err := cfg.SecretCipher.Open(key.SigningSecretEnc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt signing secret of key %d: %w", keyID, err)
+	}
+	if err := verifyRobotSignature(r, signingSecret, cfg.MaxClockSkew, seen, time.Now()); err != nil {
+		return nil, err
+	}
+	return robotRepo.FindByID(r.Context(), key.RobotID)
+}
+
 // コンテキストからユーザー情報を取得
 // ユーザ情報はUserAuthMiddleware
 func GetUserFromContext(ctx context.Context) (int, bool) {
 	userID, ok := ctx.Value(userContextKey).(int)
 	return userID, ok
 }
+
+// コンテキストからユーザーのロールを取得
+// ロールはUserAuthMiddlewareでユーザーIDと一緒にセットされる
+func GetRoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleContextKey).(string)
+	return role, ok
+}
+
+// 指定したロールのいずれかを持つユーザーのみ通す
+// UserAuthMiddlewareの後に使う
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := GetRoleFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden: Insufficient role", http.StatusForbidden)
+		})
+	}
+}
+
+// コンテキストからロボット情報を取得
+// ロボット情報はRobotAuthMiddlewareでセットされる
+func GetRobotFromContext(ctx context.Context) (*model.Robot, bool) {
+	robot, ok := ctx.Value(robotContextKey).(*model.Robot)
+	return robot, ok
+}
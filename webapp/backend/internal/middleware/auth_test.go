@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// user_sessions の代わりに使うテスト用のDB
+// SessionRepository.FindUserBySessionID の SELECT のみを扱い、SQLと同じく expires_at > now で絞り込む
+type fakeSessionDB struct {
+	sessions map[string]fakeSession
+	err      error
+}
+
+type fakeSession struct {
+	userID    int
+	role      string
+	expiresAt time.Time
+}
+
+func (db *fakeSessionDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if db.err != nil {
+		return db.err
+	}
+	sessionID, now := args[0].(string), args[1].(time.Time)
+	session, ok := db.sessions[sessionID]
+	if !ok || !session.expiresAt.After(now) {
+		return sql.ErrNoRows
+	}
+	row := reflect.ValueOf(dest).Elem()
+	row.FieldByName("UserID").SetInt(int64(session.userID))
+	row.FieldByName("Role").SetString(session.role)
+	row.FieldByName("ExpiresAt").Set(reflect.ValueOf(session.expiresAt))
+	return nil
+}
+
+func (db *fakeSessionDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return errors.New("not implemented")
+}
+
+func (db *fakeSessionDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (db *fakeSessionDB) Rebind(query string) string { return query }
+
+// Bearerトークンを使わないテストでは呼ばれない
+type rejectAllTokens struct{}
+
+func (rejectAllTokens) VerifyAccessToken(token string) (*model.SessionUser, error) {
+	return nil, errors.New("invalid token")
+}
+
+// セッションのキャッシュはパッケージ単位のため、テストごとに異なるセッションIDを使う
+func newSessionTestHandler(t *testing.T, db *fakeSessionDB) (http.Handler, *bool, *int, *string) {
+	t.Helper()
+	var (
+		called bool
+		userID int
+		role   string
+	)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		userID, _ = GetUserFromContext(r.Context())
+		role, _ = GetRoleFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	mw := UserAuthMiddleware(repository.NewSessionRepository(db), rejectAllTokens{})
+	return mw(next), &called, &userID, &role
+}
+
+func requestWithSession(sessionID string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	if sessionID != "" {
+		req.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	}
+	return req
+}
+
+func TestUserAuthMiddlewareRejectsInvalidSessions(t *testing.T) {
+	db := &fakeSessionDB{sessions: map[string]fakeSession{
+		"expired-session-0001": {userID: 1, role: model.RoleCustomer, expiresAt: time.Now().Add(-time.Minute)},
+	}}
+
+	tests := []struct {
+		name      string
+		sessionID string
+	}{
+		{name: "no cookie", sessionID: ""},
+		{name: "forged cookie", sessionID: "forged-session-0001"},
+		{name: "expired cookie", sessionID: "expired-session-0001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, called, _, _ := newSessionTestHandler(t, db)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, requestWithSession(tt.sessionID))
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			if *called {
+				t.Fatal("next handler was called for an unauthenticated request")
+			}
+		})
+	}
+}
+
+func TestSessionRepositoryReturnsErrSessionNotFound(t *testing.T) {
+	db := &fakeSessionDB{sessions: map[string]fakeSession{
+		"expired-session-0002": {userID: 1, role: model.RoleCustomer, expiresAt: time.Now().Add(-time.Second)},
+	}}
+	repo := repository.NewSessionRepository(db)
+
+	for _, sessionID := range []string{"forged-session-0002", "expired-session-0002"} {
+		user, err := repo.FindUserBySessionID(context.Background(), sessionID)
+		if !errors.Is(err, repository.ErrSessionNotFound) {
+			t.Fatalf("%s: err = %v, want ErrSessionNotFound", sessionID, err)
+		}
+		if user != nil {
+			t.Fatalf("%s: user = %+v, want nil", sessionID, user)
+		}
+	}
+}
+
+func TestUserAuthMiddlewareReportsLookupErrors(t *testing.T) {
+	db := &fakeSessionDB{err: errors.New("connection refused")}
+	handler, called, _, _ := newSessionTestHandler(t, db)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, requestWithSession("any-session-0003"))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if *called {
+		t.Fatal("next handler was called when the session lookup failed")
+	}
+}
+
+func TestUserAuthMiddlewarePassesValidSession(t *testing.T) {
+	db := &fakeSessionDB{sessions: map[string]fakeSession{
+		"valid-session-0004": {userID: 42, role: model.RoleOperator, expiresAt: time.Now().Add(time.Hour)},
+	}}
+	handler, called, userID, role := newSessionTestHandler(t, db)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, requestWithSession("valid-session-0004"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !*called {
+		t.Fatal("next handler was not called for a valid session")
+	}
+	if *userID != 42 || *role != model.RoleOperator {
+		t.Fatalf("context user = (%d, %q), want (42, %q)", *userID, *role, model.RoleOperator)
+	}
+}
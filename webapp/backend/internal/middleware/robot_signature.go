@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/cache"
+	"backend/internal/repository"
+)
+
+// 署名付きリクエストのヘッダー
+// X-Signature = hex(HMAC-SHA256(key, METHOD + "\n" + RequestURI + "\n" + X-Timestamp + "\n" + hex(SHA256(body))))
+// key は APIキーから HKDF-SHA256（info: "robot-request-signing-v1"、salt なし）で導出した32バイト
+// 平文のキーは送らず、X-API-KEY-ID でキーを指定する
+const (
+	robotKeyIDHeader     = "X-API-KEY-ID"
+	robotTimestampHeader = "X-Timestamp"
+	robotSignatureHeader = "X-Signature"
+)
+
+// 署名対象として読み込むボディの上限
+const maxSignedBodyBytes = 10 << 20
+
+var (
+	errSignatureRequired  = errors.New("signed request required")
+	errInvalidSignature   = errors.New("invalid signature")
+	errStaleTimestamp     = errors.New("timestamp outside allowed window")
+	errReplayedRequest    = errors.New("request was already used")
+	errSignedBodyTooLarge = errors.New("request body too large to verify")
+)
+
+// ロボット認証の設定
+type RobotAuthConfig struct {
+	// 台帳にないキーでもこの値と一致すればDefaultRobotIDとして扱う（空なら無効）
+	SharedAPIKey string
+	// trueの場合は X-API-KEY だけのリクエストを拒否し、署名を必須にする
+	RequireSignature bool
+	// X-Timestamp と現在時刻の許容差
+	MaxClockSkew time.Duration
+	// DBに暗号化して保存した署名用の鍵の復号に使う
+	SecretCipher *repository.RobotSecretCipher
+}
+
+// 署名の設定を環境変数から取得
+func GetRobotSignatureConfig() (requireSignature bool, maxClockSkew time.Duration) {
+	// デフォルト値
+	maxClockSkew = 5 * time.Minute
+
+	requireSignature = strings.EqualFold(os.Getenv("ROBOT_REQUIRE_SIGNATURE"), "true")
+
+	if val := os.Getenv("ROBOT_SIGNATURE_MAX_SKEW_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			maxClockSkew = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return requireSignature, maxClockSkew
+}
+
+// 署名用の鍵を暗号化する鍵（32バイトの16進表記）を環境変数から取得
+// 未設定の場合は nil を返す
+func GetRobotSigningEncryptionKey() ([]byte, error) {
+	val := os.Getenv("ROBOT_SIGNING_ENCRYPTION_KEY")
+	if val == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("ROBOT_SIGNING_ENCRYPTION_KEY must be hex encoded: %w", err)
+	}
+	return key, nil
+}
+
+// 署名付きリクエストかどうか
+func isSignedRobotRequest(r *http.Request) bool {
+	return r.Header.Get(robotSignatureHeader) != ""
+}
+
+// 署名検証前に必要なヘッダーからキーIDを取り出す
+func signedRobotKeyID(r *http.Request) (int64, error) {
+	keyID, err := strconv.ParseInt(r.Header.Get(robotKeyIDHeader), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: missing or invalid %s", errInvalidSignature, robotKeyIDHeader)
+	}
+	return keyID, nil
+}
+
+// 署名とタイムスタンプを検証する
+// 一度受け付けた署名は許容差の間記録しておき、同じリクエストの再送を拒否する
+func verifyRobotSignature(r *http.Request, signingSecret []byte, maxClockSkew time.Duration, seen *cache.MemoryCache, now time.Time) error {
+	ts, err := strconv.ParseInt(r.Header.Get(robotTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or invalid %s", errInvalidSignature, robotTimestampHeader)
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return errStaleTimestamp
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(robotSignatureHeader))
+	if err != nil {
+		return errInvalidSignature
+	}
+
+	// 上限を1バイト超えて読み、切り詰めたボディで検証しないよう上限超過は拒否する
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxSignedBodyBytes {
+		return errSignedBodyTooLarge
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, signingSecret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", r.Method, r.URL.RequestURI(), ts, hex.EncodeToString(bodyHash[:]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errInvalidSignature
+	}
+
+	// 同じ署名のリクエストが同時に届いても受け付けるのは1つだけ
+	if !seen.SetIfAbsent(hex.EncodeToString(signature), struct{}{}, 2*maxClockSkew) {
+		return errReplayedRequest
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/cache"
+)
+
+// テスト用に署名付きリクエストを組み立てる
+func newSignedRequest(t *testing.T, secret []byte, body []byte, now time.Time) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPatch, "/api/robot/orders/status", bytes.NewReader(body))
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", req.Method, req.URL.RequestURI(), now.Unix(), hex.EncodeToString(bodyHash[:]))
+	req.Header.Set(robotTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(robotSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestVerifyRobotSignatureRejectsConcurrentReplays(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	body := []byte(`{"order_id":1,"new_status":"delivered"}`)
+	now := time.Now()
+	seen := cache.NewMemoryCache()
+
+	const attempts = 32
+	reqs := make([]*http.Request, attempts)
+	for i := range reqs {
+		reqs[i] = newSignedRequest(t, secret, body, now)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+		replayed int
+	)
+	for _, req := range reqs {
+		wg.Add(1)
+		go func(req *http.Request) {
+			defer wg.Done()
+			err := verifyRobotSignature(req, secret, time.Minute, seen, now)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				accepted++
+			case errors.Is(err, errReplayedRequest):
+				replayed++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(req)
+	}
+	wg.Wait()
+
+	if accepted != 1 || replayed != attempts-1 {
+		t.Fatalf("accepted=%d replayed=%d, want 1 and %d", accepted, replayed, attempts-1)
+	}
+}
+
+func TestVerifyRobotSignatureRejectsOversizedBody(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	body := bytes.Repeat([]byte("a"), maxSignedBodyBytes+1)
+
+	err := verifyRobotSignature(newSignedRequest(t, secret, body, now), secret, time.Minute, cache.NewMemoryCache(), now)
+	if !errors.Is(err, errSignedBodyTooLarge) {
+		t.Fatalf("err = %v, want errSignedBodyTooLarge", err)
+	}
+}
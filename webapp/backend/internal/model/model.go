@@ -5,10 +5,24 @@ import (
 	"time"
 )
 
+// ユーザーのロール
+const (
+	RoleCustomer = "customer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
-	UserID       int    `db:"user_id"`
-	PasswordHash string `db:"password_hash"`
-	UserName     string `db:"user_name"`
+	UserID       int    `db:"user_id"       json:"user_id"`
+	PasswordHash string `db:"password_hash" json:"-"`
+	UserName     string `db:"user_name"     json:"user_name"`
+	Role         string `db:"role"          json:"role"`
+}
+
+// セッションから特定したユーザー
+type SessionUser struct {
+	UserID int    `db:"user_id"`
+	Role   string `db:"role"`
 }
 
 type Product struct {
@@ -16,27 +30,175 @@ type Product struct {
 	Name        string `db:"name"         json:"name"`
 	Value       int    `db:"value"        json:"value"`
 	Weight      int    `db:"weight"       json:"weight"`
+	Volume      int    `db:"volume"       json:"volume"`
 	Image       string `db:"image"        json:"image"`
 	Description string `db:"description"  json:"description"`
+	Stock       int    `db:"stock"        json:"stock"`
+}
+
+// 在庫が不足している注文明細
+type StockShortage struct {
+	ProductID int `json:"product_id"`
+	Requested int `json:"requested"`
+	Available int `json:"available"`
 }
 
 type Order struct {
 	OrderID       int64        `db:"order_id"        json:"order_id"`
 	UserID        int          `db:"user_id"         json:"user_id"`
+	CheckoutID    int64        `db:"checkout_id"     json:"checkout_id"`
+	LineID        int64        `db:"line_id"         json:"line_id"`
 	ProductID     int          `db:"product_id"      json:"product_id"`
 	ProductName   string       `db:"product_name"    json:"product_name"`
 	ShippedStatus string       `db:"shipped_status"  json:"shipped_status"`
 	Weight        int          `db:"weight"          json:"weight"`
+	Volume        int          `db:"volume"          json:"volume"`
 	Value         int          `db:"value"           json:"value"`
 	CreatedAt     time.Time    `db:"created_at"      json:"created_at"`
 	ArrivedAt     sql.NullTime `db:"arrived_at"      json:"arrived_at"`
 }
 
+// 注文の詳細（商品情報とステータスの遷移履歴を含む）
+type OrderDetail struct {
+	Order
+	ProductImage       string               `db:"image"       json:"product_image"`
+	ProductDescription string               `db:"description" json:"product_description"`
+	UnitPrice          int                  `db:"unit_price"  json:"unit_price"`
+	History            []OrderStatusHistory `db:"-"           json:"history"`
+}
+
+// 1回の購入（注文ヘッダー）
+type Checkout struct {
+	CheckoutID int64          `db:"checkout_id" json:"checkout_id"`
+	UserID     int            `db:"user_id"     json:"user_id"`
+	ItemCount  int            `db:"item_count"  json:"item_count"`
+	TotalValue int            `db:"total_value" json:"total_value"`
+	CreatedAt  time.Time      `db:"created_at"  json:"created_at"`
+	Lines      []CheckoutLine `db:"-"           json:"lines"`
+}
+
+// 購入の明細（商品ごとの数量と購入時の単価）
+// 荷物としては数量分の注文(orders)に展開される
+type CheckoutLine struct {
+	LineID      int64   `db:"line_id"      json:"line_id"`
+	CheckoutID  int64   `db:"checkout_id"  json:"checkout_id"`
+	ProductID   int     `db:"product_id"   json:"product_id"`
+	ProductName string  `db:"product_name" json:"product_name"`
+	Quantity    int     `db:"quantity"     json:"quantity"`
+	UnitPrice   int     `db:"unit_price"   json:"unit_price"`
+	OrderIDs    []int64 `db:"-"            json:"order_ids"`
+}
+
+// 注文作成リクエストの冪等キーと、最初のリクエストで作成した結果
+type OrderIdempotencyKey struct {
+	UserID      int
+	Key         string
+	RequestHash string
+	CheckoutID  int64
+	TotalValue  int
+	OrderIDs    []string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Actorは "robot:<robot_id>" のような操作主体そのもので内部向けのため、
+// 顧客にはその種別(user/robot/system)のみをActorKindとして返す
+type OrderStatusHistory struct {
+	ID         int64     `db:"id"          json:"-"`
+	OrderID    int64     `db:"order_id"    json:"order_id"`
+	FromStatus *string   `db:"from_status" json:"from_status"`
+	ToStatus   string    `db:"to_status"   json:"to_status"`
+	Actor      string    `db:"actor"       json:"-"`
+	ActorKind  string    `db:"-"           json:"actor_kind"`
+	CreatedAt  time.Time `db:"created_at"  json:"created_at"`
+}
+
+type Robot struct {
+	RobotID        string       `db:"robot_id"        json:"robot_id"`
+	Capacity       int          `db:"capacity"        json:"capacity"`
+	VolumeCapacity int          `db:"volume_capacity" json:"volume_capacity"`
+	MaxItems       int          `db:"max_items"       json:"max_items"`
+	Status         string       `db:"status"          json:"status"`
+	LastSeenAt     sql.NullTime `db:"last_seen_at"    json:"last_seen_at"`
+}
+
+// ロボットのAPIキー（平文は保存せず、発行時にのみ返す）
+// SigningSecretEnc は暗号化した署名用の鍵で、ローテーション前から残るキーではnil
+type RobotAPIKey struct {
+	KeyID            int64        `db:"key_id"             json:"key_id"`
+	RobotID          string       `db:"robot_id"           json:"robot_id"`
+	KeyHash          string       `db:"key_hash"           json:"-"`
+	SigningSecretEnc []byte       `db:"signing_secret_enc" json:"-"`
+	CreatedAt        time.Time    `db:"created_at"         json:"created_at"`
+	ExpiresAt        sql.NullTime `db:"expires_at"         json:"expires_at"`
+}
+
+// ローテーションで発行したAPIキー
+type RotatedRobotAPIKey struct {
+	KeyID   int64  `json:"key_id"`
+	RobotID string `json:"robot_id"`
+	APIKey  string `json:"api_key"`
+	// 旧キーが使えなくなる日時（旧キーがなければnull）
+	PreviousKeysExpireAt *time.Time `json:"previous_keys_expire_at"`
+}
+
 type DeliveryPlan struct {
-	RobotID     string  `json:"robot_id"`
-	TotalWeight int     `json:"total_weight"`
-	TotalValue  int     `json:"total_value"`
-	Orders      []Order `json:"orders"`
+	RobotID        string     `json:"robot_id"`
+	TotalWeight    int        `json:"total_weight"`
+	TotalVolume    int        `json:"total_volume"`
+	TotalValue     int        `json:"total_value"`
+	Orders         []Order    `json:"orders"`
+	Objective      string     `json:"objective,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	// 解法(exact/fptas/greedy)と、最適値に対して保証される比率(厳密解なら1)
+	Solver             string  `json:"solver,omitempty"`
+	Exact              bool    `json:"exact"`
+	ApproximationRatio float64 `json:"approximation_ratio"`
+	// 候補となったshipping注文の件数と、選択にかかった時間
+	CandidateCount  int   `json:"candidate_count"`
+	SolveTimeMillis int64 `json:"solve_time_ms"`
+	// ドライランで作成された計画の場合true（注文は割り当てられていない）
+	Preview bool `json:"preview,omitempty"`
+	// 履歴に記録された計画のID（プレビューでは0）
+	PlanID int64 `json:"plan_id,omitempty"`
+}
+
+// 履歴として記録された配送計画
+type DeliveryPlanRecord struct {
+	PlanID             int64        `db:"plan_id"             json:"plan_id"`
+	RobotID            string       `db:"robot_id"            json:"robot_id"`
+	Capacity           int          `db:"capacity"            json:"capacity"`
+	VolumeCapacity     int          `db:"volume_capacity"     json:"volume_capacity"`
+	MaxItems           int          `db:"max_items"           json:"max_items"`
+	Objective          string       `db:"objective"           json:"objective"`
+	Solver             string       `db:"solver"              json:"solver"`
+	Exact              bool         `db:"exact"               json:"exact"`
+	ApproximationRatio float64      `db:"approximation_ratio" json:"approximation_ratio"`
+	TotalWeight        int          `db:"total_weight"        json:"total_weight"`
+	TotalVolume        int          `db:"total_volume"        json:"total_volume"`
+	TotalValue         int          `db:"total_value"         json:"total_value"`
+	OrderCount         int          `db:"order_count"         json:"order_count"`
+	CandidateCount     int          `db:"candidate_count"     json:"candidate_count"`
+	SolveTimeMillis    int64        `db:"solve_time_ms"       json:"solve_time_ms"`
+	LeaseExpiresAt     sql.NullTime `db:"lease_expires_at"    json:"lease_expires_at"`
+	CreatedAt          time.Time    `db:"created_at"          json:"created_at"`
+	OrderIDs           []int64      `db:"-"                   json:"order_ids,omitempty"`
+}
+
+// ロボットの積載制限
+// Volume と Items が負の場合はその次元を制限しない
+type DeliveryCapacity struct {
+	Weight int
+	Volume int
+	Items  int
+}
+
+// 配送計画の選択方針
+// MustIncludeAfterが正の場合、それより長く待っている注文は積載量の許す限り必ず含める
+type DeliveryPlanOptions struct {
+	Objective         string
+	AgeBonusPerMinute int
+	MustIncludeAfter  time.Duration
 }
 
 type LoginRequest struct {
@@ -44,6 +206,32 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type RegisterRequest struct {
+	UserName string `json:"user_name"`
+	Password string `json:"password"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenResponse struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int       `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 type CreateOrderRequest struct {
 	Items []RequestItem `json:"items"`
 }
@@ -58,6 +246,21 @@ type UpdateOrderStatusRequest struct {
 	NewStatus string `json:"new_status"`
 }
 
+type BatchUpdateOrderStatusRequest struct {
+	Updates []UpdateOrderStatusRequest `json:"updates"`
+}
+
+type OrderStatusUpdateResult struct {
+	OrderID   int64  `json:"order_id"`
+	NewStatus string `json:"new_status"`
+	Updated   bool   `json:"updated"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ExtendLeaseRequest struct {
+	OrderIDs []int64 `json:"order_ids"`
+}
+
 type ListRequest struct {
 	Search    string `json:"search"`
 	Type      string `json:"type"`
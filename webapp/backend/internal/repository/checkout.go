@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"backend/internal/model"
+)
+
+type CheckoutRepository struct {
+	db DBTX
+}
+
+func NewCheckoutRepository(db DBTX) *CheckoutRepository {
+	return &CheckoutRepository{db: db}
+}
+
+// 購入ヘッダーと明細を登録し、採番されたIDを checkout と各明細にセットする
+func (r *CheckoutRepository) Create(ctx context.Context, checkout *model.Checkout) error {
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO checkouts (user_id, item_count, total_value, created_at) VALUES (?, ?, ?, NOW())",
+		checkout.UserID, checkout.ItemCount, checkout.TotalValue)
+	if err != nil {
+		return err
+	}
+	checkoutID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	checkout.CheckoutID = checkoutID
+
+	if len(checkout.Lines) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(checkout.Lines))
+	args := make([]interface{}, 0, len(checkout.Lines)*4)
+	for i, line := range checkout.Lines {
+		placeholders[i] = "(?, ?, ?, ?)"
+		args = append(args, checkoutID, line.ProductID, line.Quantity, line.UnitPrice)
+	}
+	query := "INSERT INTO checkout_lines (checkout_id, product_id, quantity, unit_price) VALUES " + strings.Join(placeholders, ", ")
+	result, err = r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	// バッチINSERTで採番されたIDは連続する
+	firstLineID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for i := range checkout.Lines {
+		checkout.Lines[i].LineID = firstLineID + int64(i)
+		checkout.Lines[i].CheckoutID = checkoutID
+	}
+	return nil
+}
+
+// 購入IDから購入ヘッダーと明細（各明細の荷物の注文IDを含む）を取得
+func (r *CheckoutRepository) FindByID(ctx context.Context, checkoutID int64) (*model.Checkout, error) {
+	var checkout model.Checkout
+	query := "SELECT checkout_id, user_id, item_count, total_value, created_at FROM checkouts WHERE checkout_id = ?"
+	if err := r.db.GetContext(ctx, &checkout, query, checkoutID); err != nil {
+		return nil, err
+	}
+
+	checkout.Lines = []model.CheckoutLine{}
+	query = `
+        SELECT l.line_id, l.checkout_id, l.product_id, p.name AS product_name, l.quantity, l.unit_price
+        FROM checkout_lines l
+        JOIN products p ON p.product_id = l.product_id
+        WHERE l.checkout_id = ?
+        ORDER BY l.line_id`
+	if err := r.db.SelectContext(ctx, &checkout.Lines, query, checkoutID); err != nil {
+		return nil, err
+	}
+
+	var parcels []struct {
+		OrderID int64 `db:"order_id"`
+		LineID  int64 `db:"line_id"`
+	}
+	query = "SELECT order_id, line_id FROM orders WHERE checkout_id = ? ORDER BY order_id"
+	if err := r.db.SelectContext(ctx, &parcels, query, checkoutID); err != nil {
+		return nil, err
+	}
+	lineIndex := make(map[int64]int, len(checkout.Lines))
+	for i := range checkout.Lines {
+		checkout.Lines[i].OrderIDs = []int64{}
+		lineIndex[checkout.Lines[i].LineID] = i
+	}
+	for _, p := range parcels {
+		if i, ok := lineIndex[p.LineID]; ok {
+			checkout.Lines[i].OrderIDs = append(checkout.Lines[i].OrderIDs, p.OrderID)
+		}
+	}
+	return &checkout, nil
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type DBTX interface {
@@ -11,3 +14,13 @@ type DBTX interface {
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 	Rebind(query string) string
 }
+
+// MySQLがデッドロックを検出した際のエラー番号
+const mysqlErrDeadlock = 1213
+
+// デッドロックによりトランザクションがロールバックされたか
+// トランザクションをやり直せば成功しうる
+func IsDeadlock(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDeadlock
+}
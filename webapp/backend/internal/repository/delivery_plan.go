@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"backend/internal/model"
+)
+
+type DeliveryPlanRepository struct {
+	db DBTX
+}
+
+func NewDeliveryPlanRepository(db DBTX) *DeliveryPlanRepository {
+	return &DeliveryPlanRepository{db: db}
+}
+
+// 配送計画と、計画に含まれる注文IDを記録し、採番された計画IDを返す
+func (r *DeliveryPlanRepository) Create(ctx context.Context, rec *model.DeliveryPlanRecord) (int64, error) {
+	query := `
+        INSERT INTO delivery_plans (
+            robot_id, capacity, volume_capacity, max_items, objective, solver, exact, approximation_ratio,
+            total_weight, total_volume, total_value, order_count, candidate_count, solve_time_ms,
+            lease_expires_at, created_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())`
+	result, err := r.db.ExecContext(ctx, query,
+		rec.RobotID, rec.Capacity, rec.VolumeCapacity, rec.MaxItems, rec.Objective, rec.Solver, rec.Exact, rec.ApproximationRatio,
+		rec.TotalWeight, rec.TotalVolume, rec.TotalValue, rec.OrderCount, rec.CandidateCount, rec.SolveTimeMillis,
+		rec.LeaseExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	planID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rec.OrderIDs) > 0 {
+		placeholders := make([]string, len(rec.OrderIDs))
+		args := make([]interface{}, 0, len(rec.OrderIDs)*2)
+		for i, orderID := range rec.OrderIDs {
+			placeholders[i] = "(?, ?)"
+			args = append(args, planID, orderID)
+		}
+		query := "INSERT INTO delivery_plan_orders (plan_id, order_id) VALUES " + strings.Join(placeholders, ", ")
+		if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+			return 0, err
+		}
+	}
+	return planID, nil
+}
+
+// 配送計画の履歴を新しい順に取得
+// robotIDが空の場合は全ロボットの計画を対象にする
+func (r *DeliveryPlanRepository) List(ctx context.Context, robotID string, limit, offset int) ([]model.DeliveryPlanRecord, int, error) {
+	where := ""
+	args := []interface{}{}
+	if robotID != "" {
+		where = " WHERE robot_id = ?"
+		args = append(args, robotID)
+	}
+
+	var total int
+	if err := r.db.GetContext(ctx, &total, "SELECT COUNT(*) FROM delivery_plans"+where, args...); err != nil {
+		return nil, 0, err
+	}
+
+	plans := []model.DeliveryPlanRecord{}
+	query := `
+        SELECT plan_id, robot_id, capacity, volume_capacity, max_items, objective, solver, exact, approximation_ratio,
+               total_weight, total_volume, total_value, order_count, candidate_count, solve_time_ms,
+               lease_expires_at, created_at
+        FROM delivery_plans` + where + `
+        ORDER BY plan_id DESC
+        LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+	if err := r.db.SelectContext(ctx, &plans, query, args...); err != nil {
+		return nil, 0, err
+	}
+	return plans, total, nil
+}
+
+// 計画IDから配送計画と含まれる注文IDを取得
+func (r *DeliveryPlanRepository) FindByID(ctx context.Context, planID int64) (*model.DeliveryPlanRecord, error) {
+	var plan model.DeliveryPlanRecord
+	query := `
+        SELECT plan_id, robot_id, capacity, volume_capacity, max_items, objective, solver, exact, approximation_ratio,
+               total_weight, total_volume, total_value, order_count, candidate_count, solve_time_ms,
+               lease_expires_at, created_at
+        FROM delivery_plans
+        WHERE plan_id = ?`
+	if err := r.db.GetContext(ctx, &plan, query, planID); err != nil {
+		return nil, err
+	}
+
+	plan.OrderIDs = []int64{}
+	if err := r.db.SelectContext(ctx, &plan.OrderIDs, "SELECT order_id FROM delivery_plan_orders WHERE plan_id = ? ORDER BY order_id", planID); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
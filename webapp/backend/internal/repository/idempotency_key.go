@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 同じユーザー・同じキーの有効な記録が既にある
+var ErrDuplicateIdempotencyKey = errors.New("idempotency key already exists")
+
+type IdempotencyKeyRepository struct {
+	db DBTX
+}
+
+func NewIdempotencyKeyRepository(db DBTX) *IdempotencyKeyRepository {
+	return &IdempotencyKeyRepository{db: db}
+}
+
+// 冪等キーを登録する
+// 有効な記録が既にあれば ErrDuplicateIdempotencyKey を返す（期限切れの記録は置き換える）
+// 同じキーで処理中のトランザクションがある場合は、その完了まで待ってから判定される
+func (r *IdempotencyKeyRepository) Reserve(ctx context.Context, userID int, key, requestHash string, expiresAt time.Time) error {
+	// expires_at は判定に使うため最後に更新する
+	query := `
+        INSERT INTO order_idempotency_keys (user_id, idempotency_key, request_hash, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            request_hash = IF(expires_at <= VALUES(created_at), VALUES(request_hash), request_hash),
+            checkout_id = IF(expires_at <= VALUES(created_at), NULL, checkout_id),
+            total_value = IF(expires_at <= VALUES(created_at), 0, total_value),
+            order_ids = IF(expires_at <= VALUES(created_at), NULL, order_ids),
+            created_at = IF(expires_at <= VALUES(created_at), VALUES(created_at), created_at),
+            expires_at = IF(expires_at <= VALUES(created_at), VALUES(expires_at), expires_at)`
+	result, err := r.db.ExecContext(ctx, query, userID, key, requestHash, time.Now(), expiresAt)
+	if err != nil {
+		return err
+	}
+	// 1: 新規登録、2: 期限切れの記録を置き換え、0: 有効な記録が既にある
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrDuplicateIdempotencyKey
+	}
+	return nil
+}
+
+// 冪等キーに注文作成の結果を保存する
+func (r *IdempotencyKeyRepository) SaveResult(ctx context.Context, userID int, key string, checkoutID int64, totalValue int, orderIDs []string) error {
+	encoded, err := json.Marshal(orderIDs)
+	if err != nil {
+		return err
+	}
+	var checkout sql.NullInt64
+	if checkoutID != 0 {
+		checkout = sql.NullInt64{Int64: checkoutID, Valid: true}
+	}
+	query := `
+        UPDATE order_idempotency_keys SET checkout_id = ?, total_value = ?, order_ids = ?
+        WHERE user_id = ? AND idempotency_key = ?`
+	_, err = r.db.ExecContext(ctx, query, checkout, totalValue, string(encoded), userID, key)
+	return err
+}
+
+// 有効な冪等キーの記録を取得
+// 存在しない、または期限切れの場合は sql.ErrNoRows を返す
+func (r *IdempotencyKeyRepository) Find(ctx context.Context, userID int, key string) (*model.OrderIdempotencyKey, error) {
+	var row struct {
+		RequestHash string         `db:"request_hash"`
+		CheckoutID  sql.NullInt64  `db:"checkout_id"`
+		TotalValue  int            `db:"total_value"`
+		OrderIDs    sql.NullString `db:"order_ids"`
+		CreatedAt   time.Time      `db:"created_at"`
+		ExpiresAt   time.Time      `db:"expires_at"`
+	}
+	query := `
+        SELECT request_hash, checkout_id, total_value, order_ids, created_at, expires_at
+        FROM order_idempotency_keys
+        WHERE user_id = ? AND idempotency_key = ? AND expires_at > ?`
+	if err := r.db.GetContext(ctx, &row, query, userID, key, time.Now()); err != nil {
+		return nil, err
+	}
+
+	record := &model.OrderIdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		RequestHash: row.RequestHash,
+		CheckoutID:  row.CheckoutID.Int64,
+		TotalValue:  row.TotalValue,
+		CreatedAt:   row.CreatedAt,
+		ExpiresAt:   row.ExpiresAt,
+	}
+	if row.OrderIDs.Valid {
+		if err := json.Unmarshal([]byte(row.OrderIDs.String), &record.OrderIDs); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// 期限切れの冪等キーを削除し、削除件数を返す
+func (r *IdempotencyKeyRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM order_idempotency_keys WHERE expires_at <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
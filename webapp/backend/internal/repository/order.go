@@ -18,10 +18,14 @@ type OrderRepository struct {
 	cache *cache.MemoryCache
 }
 
+// 注文件数のキャッシュ
+// トランザクション用のStoreで行った無効化も反映されるようパッケージ単位で持つ
+var orderCountCache = cache.NewMemoryCache()
+
 func NewOrderRepository(db DBTX) *OrderRepository {
 	return &OrderRepository{
 		db:    db,
-		cache: cache.NewMemoryCache(),
+		cache: orderCountCache,
 	}
 }
 
@@ -32,13 +36,13 @@ func (r *OrderRepository) CreateBatch(ctx context.Context, orders []*model.Order
 	}
 
 	// バッチINSERT用のクエリを構築
-	query := `INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES `
+	query := `INSERT INTO orders (user_id, product_id, checkout_id, line_id, shipped_status, created_at) VALUES `
 	placeholders := make([]string, len(orders))
 	args := make([]interface{}, 0, len(orders)*4)
 
 	for i, order := range orders {
-		placeholders[i] = "(?, ?, 'shipping', NOW())"
-		args = append(args, order.UserID, order.ProductID)
+		placeholders[i] = "(?, ?, ?, ?, 'shipping', NOW())"
+		args = append(args, order.UserID, order.ProductID, order.CheckoutID, order.LineID)
 	}
 
 	query += strings.Join(placeholders, ", ")
@@ -85,33 +89,149 @@ func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (strin
 }
 
 // 複数の注文IDのステータスを一括で更新
-// 主に配送ロボットが注文を引き受けた際に一括更新をするために使用
+// deliveredへの更新ではarrived_atを記録し、shippingへ戻す場合はロボットの割り当てとリースを解除する
 func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, newStatus string) error {
 	if len(orderIDs) == 0 {
 		return nil
 	}
-	query, args, err := sqlx.In("UPDATE orders SET shipped_status = ? WHERE order_id IN (?)", newStatus, orderIDs)
+	set := "shipped_status = ?"
+	switch newStatus {
+	case "delivered":
+		set += ", arrived_at = NOW()"
+	case "shipping":
+		set += ", robot_id = NULL, lease_expires_at = NULL"
+	}
+	query, args, err := sqlx.In("UPDATE orders SET "+set+" WHERE order_id IN (?)", newStatus, orderIDs)
 	if err != nil {
 		return err
 	}
 	query = r.db.Rebind(query)
 	_, err = r.db.ExecContext(ctx, query, args...)
-	
+
 	// ステータスが更新されたのでキャッシュを無効化
 	if err == nil {
 		r.invalidateOrderCountCache()
 	}
-	
+
+	return err
+}
+
+// 行ロックを取得した注文の現在のステータスと割り当て先のロボット
+type LockedOrderStatus struct {
+	ShippedStatus string         `db:"shipped_status"`
+	RobotID       sql.NullString `db:"robot_id"`
+}
+
+// 複数の注文の現在のステータスと割り当て先のロボットを行ロック付きで取得
+// 存在しない注文IDは結果のmapに含まれない
+func (r *OrderRepository) GetStatusesForUpdate(ctx context.Context, orderIDs []int64) (map[int64]LockedOrderStatus, error) {
+	statuses := make(map[int64]LockedOrderStatus, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return statuses, nil
+	}
+	query, args, err := sqlx.In("SELECT order_id, shipped_status, robot_id FROM orders WHERE order_id IN (?) FOR UPDATE", orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	query = r.db.Rebind(query)
+
+	var rows []struct {
+		OrderID int64 `db:"order_id"`
+		LockedOrderStatus
+	}
+	if err := r.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		statuses[row.OrderID] = row.LockedOrderStatus
+	}
+	return statuses, nil
+}
+
+// ステータス遷移履歴を一括で記録
+func (r *OrderRepository) InsertStatusHistory(ctx context.Context, entries []model.OrderStatusHistory) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO order_status_history (order_id, from_status, to_status, actor, created_at) VALUES `
+	placeholders := make([]string, len(entries))
+	args := make([]interface{}, 0, len(entries)*4)
+
+	for i, entry := range entries {
+		placeholders[i] = "(?, ?, ?, ?, NOW())"
+		args = append(args, entry.OrderID, entry.FromStatus, entry.ToStatus, entry.Actor)
+	}
+
+	query += strings.Join(placeholders, ", ")
+	_, err := r.db.ExecContext(ctx, query, args...)
 	return err
 }
 
+// 注文をロボットに割り当て、ステータスをdeliveringに一括更新し、割り当てられた件数を返す
+// どのロボットがどの注文を引き受けたかをrobot_idに、引き受け期限をlease_expires_atに記録する
+// shippingのままの注文だけを更新するため、他のロボットが先に引き受けた注文は件数に含まれない
+func (r *OrderRepository) AssignToRobot(ctx context.Context, orderIDs []int64, robotID string, leaseExpiresAt time.Time) (int64, error) {
+	if len(orderIDs) == 0 {
+		return 0, nil
+	}
+	query, args, err := sqlx.In("UPDATE orders SET shipped_status = 'delivering', robot_id = ?, lease_expires_at = ? WHERE order_id IN (?) AND shipped_status = 'shipping'", robotID, leaseExpiresAt, orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	query = r.db.Rebind(query)
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	// ステータスが更新されたのでキャッシュを無効化
+	r.invalidateOrderCountCache()
+
+	return result.RowsAffected()
+}
+
+// ロボットが保持しているdelivering注文のリース期限を延長し、延長した件数を返す
+// orderIDsが空の場合はそのロボットの全てのdelivering注文を対象にする
+func (r *OrderRepository) ExtendLeases(ctx context.Context, robotID string, orderIDs []int64, leaseExpiresAt time.Time) (int64, error) {
+	query := "UPDATE orders SET lease_expires_at = ? WHERE robot_id = ? AND shipped_status = 'delivering'"
+	args := []interface{}{leaseExpiresAt, robotID}
+	if len(orderIDs) > 0 {
+		var err error
+		query, args, err = sqlx.In(query+" AND order_id IN (?)", leaseExpiresAt, robotID, orderIDs)
+		if err != nil {
+			return 0, err
+		}
+		query = r.db.Rebind(query)
+	}
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// リース期限切れのdelivering注文のIDを行ロック付きで取得
+func (r *OrderRepository) FindExpiredLeaseOrderIDs(ctx context.Context, now time.Time) ([]int64, error) {
+	var orderIDs []int64
+	query := `
+        SELECT order_id
+        FROM orders
+        WHERE shipped_status = 'delivering' AND lease_expires_at < ?
+        FOR UPDATE`
+	err := r.db.SelectContext(ctx, &orderIDs, query, now)
+	return orderIDs, err
+}
+
 // 配送中(shipped_status:shipping)の注文一覧を取得
 func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order, error) {
 	var orders []model.Order
 	query := `
         SELECT
             o.order_id,
+            o.created_at,
             p.weight,
+            p.volume,
             p.value
         FROM orders o
         JOIN products p ON o.product_id = p.product_id
@@ -121,6 +241,47 @@ func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order,
 	return orders, err
 }
 
+// ユーザーの注文を商品情報とステータスの遷移履歴付きで取得
+// 他のユーザーの注文は存在しない場合と同じく sql.ErrNoRows を返す
+func (r *OrderRepository) FindDetailForUser(ctx context.Context, userID int, orderID int64) (*model.OrderDetail, error) {
+	var detail model.OrderDetail
+	query := `
+        SELECT
+            o.order_id,
+            o.user_id,
+            COALESCE(o.checkout_id, 0) AS checkout_id,
+            COALESCE(o.line_id, 0) AS line_id,
+            o.product_id,
+            p.name AS product_name,
+            o.shipped_status,
+            p.weight,
+            p.volume,
+            p.value,
+            o.created_at,
+            o.arrived_at,
+            p.image,
+            p.description,
+            COALESCE(l.unit_price, p.value) AS unit_price
+        FROM orders o
+        JOIN products p ON p.product_id = o.product_id
+        LEFT JOIN checkout_lines l ON l.line_id = o.line_id
+        WHERE o.order_id = ? AND o.user_id = ?`
+	if err := r.db.GetContext(ctx, &detail, query, orderID, userID); err != nil {
+		return nil, err
+	}
+
+	detail.History = []model.OrderStatusHistory{}
+	query = `
+        SELECT id, order_id, from_status, to_status, actor, created_at
+        FROM order_status_history
+        WHERE order_id = ?
+        ORDER BY id`
+	if err := r.db.SelectContext(ctx, &detail.History, query, orderID); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
 // 注文履歴一覧を取得
 func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
 	// キャッシュキーを生成（ユーザーIDと検索条件に基づく）
@@ -162,7 +323,7 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 
 	// メインクエリ
 	query := `
-        SELECT o.order_id, o.product_id, p.name as product_name, o.shipped_status, o.created_at, o.arrived_at
+        SELECT o.order_id, o.checkout_id, o.product_id, p.name as product_name, o.shipped_status, o.created_at, o.arrived_at
         FROM orders o
         JOIN products p ON o.product_id = p.product_id
         WHERE o.user_id = ?`
@@ -201,12 +362,13 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 	args = append(args, req.PageSize, req.Offset)
 
 	type orderRow struct {
-		OrderID       int64        `db:"order_id"`
-		ProductID     int          `db:"product_id"`
-		ProductName   string       `db:"product_name"`
-		ShippedStatus string       `db:"shipped_status"`
-		CreatedAt     sql.NullTime `db:"created_at"`
-		ArrivedAt     sql.NullTime `db:"arrived_at"`
+		OrderID       int64         `db:"order_id"`
+		CheckoutID    sql.NullInt64 `db:"checkout_id"`
+		ProductID     int           `db:"product_id"`
+		ProductName   string        `db:"product_name"`
+		ShippedStatus string        `db:"shipped_status"`
+		CreatedAt     sql.NullTime  `db:"created_at"`
+		ArrivedAt     sql.NullTime  `db:"arrived_at"`
 	}
 	var ordersRaw []orderRow
 	if err := r.db.SelectContext(ctx, &ordersRaw, query, args...); err != nil {
@@ -217,6 +379,7 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 	for _, o := range ordersRaw {
 		orders = append(orders, model.Order{
 			OrderID:       o.OrderID,
+			CheckoutID:    o.CheckoutID.Int64,
 			ProductID:     o.ProductID,
 			ProductName:   o.ProductName,
 			ShippedStatus: o.ShippedStatus,
@@ -244,12 +407,42 @@ func (r *OrderRepository) generateOrderCountCacheKey(userID int, search, searchT
 func (r *OrderRepository) invalidateOrderCountCache() {
 	// 注文関連のキャッシュを削除
 	// 実装を簡単にするため、今回はキャッシュ全体をクリア
-	r.cache = cache.NewMemoryCache()
+	r.cache.Clear()
 }
 
-// 特定ユーザーの注文キャッシュのみを無効化する（より細かい制御が必要な場合）
+// 特定ユーザーの注文件数キャッシュのみを無効化する
 func (r *OrderRepository) InvalidateUserOrderCountCache(userID int) {
-	// より効率的な実装では、ユーザー別のキーのみを削除
-	// 現在の実装では全体をクリアしているが、将来的に改善可能
-	r.invalidateOrderCountCache()
+	r.cache.DeletePrefix(fmt.Sprintf("order_count:user:%d:", userID))
+}
+
+// ユーザーの配送待ち(shipping)の注文をキャンセルする
+// ロボットへの割り当て(AssignToRobot)と同じく shipping の場合のみ更新するため、
+// 同時に引き受けられた注文は更新されず false を返す
+func (r *OrderRepository) CancelShipping(ctx context.Context, userID int, orderID int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE orders SET shipped_status = 'cancelled' WHERE order_id = ? AND user_id = ? AND shipped_status = 'shipping'",
+		orderID, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 1 {
+		r.invalidateOrderCountCache()
+	}
+	return affected == 1, nil
+}
+
+// 注文の持ち主と現在のステータスを取得
+func (r *OrderRepository) GetOwnerAndStatus(ctx context.Context, orderID int64) (int, string, error) {
+	var row struct {
+		UserID        int    `db:"user_id"`
+		ShippedStatus string `db:"shipped_status"`
+	}
+	if err := r.db.GetContext(ctx, &row, "SELECT user_id, shipped_status FROM orders WHERE order_id = ?", orderID); err != nil {
+		return 0, "", err
+	}
+	return row.UserID, row.ShippedStatus, nil
 }
@@ -7,6 +7,8 @@ import (
 	"crypto/md5"
 	"fmt"
 	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
 type ProductRepository struct {
@@ -14,10 +16,14 @@ type ProductRepository struct {
 	cache *cache.MemoryCache
 }
 
+// 商品件数のキャッシュ
+// トランザクションごとに作られるStoreでも同じキャッシュを使うようパッケージ単位で持つ
+var productCountCache = cache.NewMemoryCache()
+
 func NewProductRepository(db DBTX) *ProductRepository {
 	return &ProductRepository{
 		db:    db,
-		cache: cache.NewMemoryCache(),
+		cache: productCountCache,
 	}
 }
 
@@ -54,7 +60,7 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 	
 	// データを取得（プレースホルダーを使用してSQLインジェクションを防止）
 	baseQuery := `
-		SELECT product_id, name, value, weight, image, description
+		SELECT product_id, name, value, weight, volume, image, description, stock
 		FROM products
 	`
 	args := []interface{}{}
@@ -71,7 +77,9 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 		"name":        true,
 		"value":       true,
 		"weight":      true,
+		"volume":      true,
 		"description": true,
+		"stock":       true,
 	}
 	if !allowedSortFields[req.SortField] {
 		req.SortField = "product_id"
@@ -108,5 +116,61 @@ func (r *ProductRepository) generateCountCacheKey(search string) string {
 func (r *ProductRepository) InvalidateCountCache() {
 	// 全てのカウントキャッシュを削除
 	// 実装を簡単にするため、今回はキャッシュ全体をクリア
-	r.cache = cache.NewMemoryCache()
+	r.cache.Clear()
+}
+
+// 商品IDの一覧から商品を取得し、在庫を引き当てるため行をロックする
+// デッドロックを避けるため商品ID順にロックする（存在しないIDは結果に含まれない）
+func (r *ProductRepository) FindByIDsForUpdate(ctx context.Context, productIDs []int) ([]model.Product, error) {
+	products := []model.Product{}
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+	query, args, err := sqlx.In("SELECT product_id, name, value, weight, volume, stock FROM products WHERE product_id IN (?) ORDER BY product_id FOR UPDATE", productIDs)
+	if err != nil {
+		return nil, err
+	}
+	query = r.db.Rebind(query)
+	if err := r.db.SelectContext(ctx, &products, query, args...); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// 在庫を引き当てる
+// 在庫が不足している場合は更新せず false を返す
+func (r *ProductRepository) ReserveStock(ctx context.Context, productID, quantity int) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE products SET stock = stock - ? WHERE product_id = ? AND stock >= ?",
+		quantity, productID, quantity)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// 注文の商品ごとの個数分だけ在庫を戻す（キャンセル時）
+func (r *ProductRepository) ReleaseStockForOrders(ctx context.Context, orderIDs []int64) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In(`
+        UPDATE products p
+        JOIN (
+            SELECT product_id, COUNT(*) AS quantity
+            FROM orders
+            WHERE order_id IN (?)
+            GROUP BY product_id
+        ) o ON o.product_id = p.product_id
+        SET p.stock = p.stock + o.quantity`, orderIDs)
+	if err != nil {
+		return err
+	}
+	query = r.db.Rebind(query)
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
 }
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type RefreshTokenRepository struct {
+	db DBTX
+}
+
+func NewRefreshTokenRepository(db DBTX) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// リフレッシュトークンを登録する（平文は保存しない）
+func (r *RefreshTokenRepository) Create(ctx context.Context, userID int, token string, expiresAt time.Time) error {
+	query := "INSERT INTO user_refresh_tokens (token_hash, user_id, expires_at, created_at) VALUES (?, ?, ?, NOW())"
+	_, err := r.db.ExecContext(ctx, query, hashRefreshToken(token), userID, expiresAt)
+	return err
+}
+
+// 有効なリフレッシュトークンを使用済みにし、持ち主のユーザーIDを返す
+// 存在しない・期限切れ・使用済みのトークンは sql.ErrNoRows を返す
+// 同じトークンが同時に使われても1回しか成功しないよう、行ロックを取ってから更新する
+func (r *RefreshTokenRepository) Consume(ctx context.Context, token string) (int, error) {
+	var row struct {
+		ID     int64 `db:"id"`
+		UserID int   `db:"user_id"`
+	}
+	query := `
+        SELECT id, user_id FROM user_refresh_tokens
+        WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+        FOR UPDATE`
+	if err := r.db.GetContext(ctx, &row, query, hashRefreshToken(token), time.Now()); err != nil {
+		return 0, err
+	}
+	if _, err := r.db.ExecContext(ctx, "UPDATE user_refresh_tokens SET revoked_at = NOW() WHERE id = ?", row.ID); err != nil {
+		return 0, err
+	}
+	return row.UserID, nil
+}
+
+// ユーザーの全リフレッシュトークンを失効させ、件数を返す
+func (r *RefreshTokenRepository) RevokeByUserID(ctx context.Context, userID int) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE user_refresh_tokens SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL", userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 期限切れ・失効済みのトークンを削除し、削除件数を返す
+func (r *RefreshTokenRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		"DELETE FROM user_refresh_tokens WHERE expires_at <= ? OR revoked_at IS NOT NULL", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// リフレッシュトークンのハッシュ（SHA-256の16進表記）
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
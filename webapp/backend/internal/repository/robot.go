@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"backend/internal/model"
+)
+
+type RobotRepository struct {
+	db DBTX
+}
+
+func NewRobotRepository(db DBTX) *RobotRepository {
+	return &RobotRepository{db: db}
+}
+
+// APIキーからロボット情報を取得
+// ロボット認証時に使用。失効・期限切れのキーは対象外
+func (r *RobotRepository) FindByAPIKey(ctx context.Context, apiKey string) (*model.Robot, error) {
+	var robot model.Robot
+	query := `
+        SELECT r.robot_id, r.capacity, r.volume_capacity, r.max_items, r.status, r.last_seen_at
+        FROM robot_api_keys k
+        JOIN robots r ON r.robot_id = k.robot_id
+        WHERE k.key_hash = ?
+          AND k.revoked_at IS NULL
+          AND (k.expires_at IS NULL OR k.expires_at > NOW())`
+	if err := r.db.GetContext(ctx, &robot, query, HashAPIKey(apiKey)); err != nil {
+		return nil, err
+	}
+	return &robot, nil
+}
+
+// キーIDから有効なAPIキーを取得
+// 署名付きリクエストの検証時に使用
+func (r *RobotRepository) FindActiveAPIKey(ctx context.Context, keyID int64) (*model.RobotAPIKey, error) {
+	var key model.RobotAPIKey
+	query := `
+        SELECT key_id, robot_id, key_hash, signing_secret_enc, created_at, expires_at
+        FROM robot_api_keys
+        WHERE key_id = ?
+          AND revoked_at IS NULL
+          AND (expires_at IS NULL OR expires_at > NOW())`
+	if err := r.db.GetContext(ctx, &key, query, keyID); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// 有効なAPIキーのうち、指定した平文キーと一致するものがあるか
+func (r *RobotRepository) HasActiveAPIKey(ctx context.Context, apiKey string) (bool, error) {
+	var exists bool
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM robot_api_keys
+            WHERE key_hash = ?
+              AND revoked_at IS NULL
+              AND (expires_at IS NULL OR expires_at > NOW())
+        )`
+	err := r.db.GetContext(ctx, &exists, query, HashAPIKey(apiKey))
+	return exists, err
+}
+
+// APIキーを登録し、採番されたキーIDを返す
+// 平文は保存せず、照合用のハッシュと暗号化した署名用の鍵のみを保存する
+func (r *RobotRepository) CreateAPIKey(ctx context.Context, robotID, apiKey string, sealedSigningSecret []byte) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO robot_api_keys (robot_id, key_hash, signing_secret_enc, created_at) VALUES (?, ?, ?, NOW())",
+		robotID, HashAPIKey(apiKey), sealedSigningSecret)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// keepKeyID 以外の有効なキーの期限を expiresAt までに縮め、更新件数を返す
+// 既に expiresAt より早く切れるキーはそのままにする
+func (r *RobotRepository) ExpireOtherAPIKeys(ctx context.Context, robotID string, keepKeyID int64, expiresAt time.Time) (int64, error) {
+	query := `
+        UPDATE robot_api_keys SET expires_at = ?
+        WHERE robot_id = ?
+          AND key_id <> ?
+          AND revoked_at IS NULL
+          AND (expires_at IS NULL OR expires_at > ?)`
+	result, err := r.db.ExecContext(ctx, query, expiresAt, robotID, keepKeyID, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// APIキーのハッシュ（SHA-256の16進表記）
+// キーの照合にのみ使い、署名の鍵には使わない（DeriveRobotSigningSecret を参照）
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// ロボットIDからロボット情報を取得
+func (r *RobotRepository) FindByID(ctx context.Context, robotID string) (*model.Robot, error) {
+	var robot model.Robot
+	query := "SELECT robot_id, capacity, volume_capacity, max_items, status, last_seen_at FROM robots WHERE robot_id = ?"
+	if err := r.db.GetContext(ctx, &robot, query, robotID); err != nil {
+		return nil, err
+	}
+	return &robot, nil
+}
+
+// 登録済みロボットの一覧を取得
+func (r *RobotRepository) List(ctx context.Context) ([]model.Robot, error) {
+	robots := []model.Robot{}
+	query := "SELECT robot_id, capacity, volume_capacity, max_items, status, last_seen_at FROM robots ORDER BY robot_id"
+	err := r.db.SelectContext(ctx, &robots, query)
+	return robots, err
+}
+
+// 最終アクセス日時を更新
+func (r *RobotRepository) TouchLastSeen(ctx context.Context, robotID string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE robots SET last_seen_at = NOW() WHERE robot_id = ?", robotID)
+	return err
+}
+
+// ロボットの稼働状態を更新
+func (r *RobotRepository) UpdateStatus(ctx context.Context, robotID, status string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE robots SET status = ? WHERE robot_id = ?", status, robotID)
+	return err
+}
+
+// 配送中の注文が残っていなければロボットを待機状態に戻す
+func (r *RobotRepository) MarkIdleIfNoDeliveries(ctx context.Context, robotID string) error {
+	query := `
+        UPDATE robots SET status = 'idle'
+        WHERE robot_id = ?
+          AND NOT EXISTS (SELECT 1 FROM orders WHERE robot_id = ? AND shipped_status = 'delivering')`
+	_, err := r.db.ExecContext(ctx, query, robotID, robotID)
+	return err
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// 署名用の鍵を導出する際の用途ラベル
+// 同じAPIキーから key_hash とは別の値が得られるようにする
+const robotSigningSecretInfo = "robot-request-signing-v1"
+
+// 署名用の鍵のバイト数
+const robotSigningSecretBytes = 32
+
+var errMalformedSealedSecret = errors.New("malformed sealed signing secret")
+
+// APIキーからリクエスト署名用の鍵を導出する（HKDF-SHA256）
+// ロボットも同じ手順で平文のキーから導出し、HMACの鍵として使う
+func DeriveRobotSigningSecret(apiKey string) ([]byte, error) {
+	secret := make([]byte, robotSigningSecretBytes)
+	r := hkdf.New(sha256.New, []byte(apiKey), nil, []byte(robotSigningSecretInfo))
+	if _, err := io.ReadFull(r, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// 署名用の鍵をDBに保存する前に暗号化する（AES-256-GCM）
+// 暗号化の鍵はDBとは別に環境変数で渡すため、テーブルを読めても署名は作れない
+type RobotSecretCipher struct {
+	aead cipher.AEAD
+}
+
+func NewRobotSecretCipher(key []byte) (*RobotSecretCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("robot signing encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &RobotSecretCipher{aead: aead}, nil
+}
+
+// 署名用の鍵を暗号化し、nonce を先頭に付けて返す
+func (c *RobotSecretCipher) Seal(secret []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, secret, nil), nil
+}
+
+// Seal で暗号化した署名用の鍵を復号する
+func (c *RobotSecretCipher) Open(sealed []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errMalformedSealedSecret
+	}
+	return c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+}
@@ -1,24 +1,37 @@
 package repository
 
 import (
-	
+	"backend/internal/cache"
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
+// 存在しない、または期限切れのセッション
+var ErrSessionNotFound = errors.New("session not found")
+
 type SessionRepository struct {
 	db DBTX
 }
 
-var Session_cache = make(map[string]int)
+// セッションID → ユーザーID・ロールの読み込みキャッシュ
+// トランザクション用のStoreとも共有するためパッケージ単位で持つ
+var sessionCache = cache.NewMemoryCache()
+
+// キャッシュに保持する最大時間（失効を他プロセスへ反映するまでの上限）
+const sessionCacheTTL = 60 * time.Second
 
 func NewSessionRepository(db DBTX) *SessionRepository {
 	return &SessionRepository{db: db}
 }
 
 // セッションを作成し、セッションIDと有効期限を返す
-func (r *SessionRepository) Create(userBusinessID int, duration time.Duration) (string, time.Time, error) {
+func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, duration time.Duration) (string, time.Time, error) {
 	sessionUUID, err := uuid.NewRandom()
 	if err != nil {
 		return "", time.Time{}, err
@@ -26,34 +39,120 @@ func (r *SessionRepository) Create(userBusinessID int, duration time.Duration) (
 	expiresAt := time.Now().Add(duration)
 	sessionIDStr := sessionUUID.String()
 
-	// TODO: check expire at
-	Session_cache[sessionIDStr] = userBusinessID;
-	//query := "INSERT INTO user_sessions (session_uuid, user_id, expires_at) VALUES (?, ?, ?)"
-	//_, err = r.db.ExecContext(ctx, query, sessionIDStr, userBusinessID, expiresAt)
-	//if err != nil {
-	//	return "", time.Time{}, err
-	//}
+	query := "INSERT INTO user_sessions (session_uuid, user_id, expires_at) VALUES (?, ?, ?)"
+	_, err = r.db.ExecContext(ctx, query, sessionIDStr, userBusinessID, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
 	return sessionIDStr, expiresAt, nil
 }
 
-// セッションIDからユーザーIDを取得
-func (r *SessionRepository) FindUserBySessionID(sessionID string) (int, error) {
-	//fmt.Println("called query for user!\n")
-	//var userID int
-	if uid, ok :=  Session_cache[sessionID]; ok {
-		return uid, nil
-	} else {
-		return 0, nil
-	}
-	// query := `
-	// 	SELECT 
-	// 		u.user_id
-	// 	FROM users u
-	// 	JOIN user_sessions s ON u.user_id = s.user_id
-	// 	WHERE s.session_uuid = ? AND s.expires_at > ?`
-	// err := r.db.GetContext(ctx, &userID, query, sessionID, time.Now())
-	// if err != nil {
-	// 	return 0, err
-	// }
-	// return userID, nil
+// セッションIDからユーザーIDとロールを取得
+// 期限切れや存在しないセッションの場合は ErrSessionNotFound を返す
+func (r *SessionRepository) FindUserBySessionID(ctx context.Context, sessionID string) (*model.SessionUser, error) {
+	if cached, ok := sessionCache.Get(sessionID); ok {
+		user := cached.(model.SessionUser)
+		return &user, nil
+	}
+
+	var session struct {
+		model.SessionUser
+		ExpiresAt time.Time `db:"expires_at"`
+	}
+	query := `
+		SELECT
+			s.user_id,
+			u.role,
+			s.expires_at
+		FROM user_sessions s
+		JOIN users u ON u.user_id = s.user_id
+		WHERE s.session_uuid = ? AND s.expires_at > ?`
+	err := r.db.GetContext(ctx, &session, query, sessionID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSession(sessionID, session.SessionUser, session.ExpiresAt)
+	return &session.SessionUser, nil
+}
+
+// セッションを削除する（ログアウト）
+func (r *SessionRepository) Delete(ctx context.Context, sessionID string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE session_uuid = ?", sessionID); err != nil {
+		return err
+	}
+	sessionCache.Delete(sessionID)
+	return nil
+}
+
+// ユーザーの全セッションを削除し、削除したセッションIDを返す
+// キャッシュからの削除は DeleteOthers と同じくコミット後に呼び出し側で行う
+func (r *SessionRepository) DeleteByUserID(ctx context.Context, userID int) ([]string, error) {
+	return r.DeleteOthers(ctx, userID, "")
+}
+
+// ユーザーのセッションのうち keepSessionID 以外を削除し、削除したセッションIDを返す
+// トランザクション内で呼ばれるため、キャッシュからの削除はコミット後に呼び出し側が EvictCachedSessions で行う
+// （コミット前に消すと、並行するリクエストがまだ見えている行を読んでキャッシュに載せ直してしまう）
+func (r *SessionRepository) DeleteOthers(ctx context.Context, userID int, keepSessionID string) ([]string, error) {
+	sessionIDs := []string{}
+	query := "SELECT session_uuid FROM user_sessions WHERE user_id = ? AND session_uuid <> ? FOR UPDATE"
+	if err := r.db.SelectContext(ctx, &sessionIDs, query, userID, keepSessionID); err != nil {
+		return nil, err
+	}
+	if len(sessionIDs) == 0 {
+		return sessionIDs, nil
+	}
+
+	query, args, err := sqlx.In("DELETE FROM user_sessions WHERE session_uuid IN (?)", sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return sessionIDs, nil
+}
+
+// 削除したセッションをキャッシュから外す
+func EvictCachedSessions(sessionIDs []string) {
+	for _, sessionID := range sessionIDs {
+		sessionCache.Delete(sessionID)
+	}
+}
+
+// ユーザーのセッションをキャッシュから外し、次のリクエストでロールを読み直させる
+func (r *SessionRepository) InvalidateUserCache(ctx context.Context, userID int) error {
+	sessionIDs := []string{}
+	if err := r.db.SelectContext(ctx, &sessionIDs, "SELECT session_uuid FROM user_sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		sessionCache.Delete(sessionID)
+	}
+	return nil
+}
+
+// 期限切れのセッションを削除し、削除件数を返す
+func (r *SessionRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE expires_at <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// セッションをキャッシュに載せる（有効期限を超えて保持しない）
+func cacheSession(sessionID string, user model.SessionUser, expiresAt time.Time) {
+	ttl := time.Until(expiresAt)
+	if ttl > sessionCacheTTL {
+		ttl = sessionCacheTTL
+	}
+	if ttl > 0 {
+		sessionCache.Set(sessionID, user, ttl)
+	}
 }
@@ -7,20 +7,30 @@ import (
 )
 
 type Store struct {
-	db          DBTX
-	UserRepo    *UserRepository
-	SessionRepo *SessionRepository
-	ProductRepo *ProductRepository
-	OrderRepo   *OrderRepository
+	db               DBTX
+	UserRepo         *UserRepository
+	SessionRepo      *SessionRepository
+	RefreshTokenRepo *RefreshTokenRepository
+	ProductRepo      *ProductRepository
+	OrderRepo        *OrderRepository
+	CheckoutRepo     *CheckoutRepository
+	IdempotencyRepo  *IdempotencyKeyRepository
+	RobotRepo        *RobotRepository
+	PlanRepo         *DeliveryPlanRepository
 }
 
 func NewStore(db DBTX) *Store {
 	return &Store{
-		db:          db,
-		UserRepo:    NewUserRepository(db),
-		SessionRepo: NewSessionRepository(db),
-		ProductRepo: NewProductRepository(db),
-		OrderRepo:   NewOrderRepository(db),
+		db:               db,
+		UserRepo:         NewUserRepository(db),
+		SessionRepo:      NewSessionRepository(db),
+		RefreshTokenRepo: NewRefreshTokenRepository(db),
+		ProductRepo:      NewProductRepository(db),
+		OrderRepo:        NewOrderRepository(db),
+		CheckoutRepo:     NewCheckoutRepository(db),
+		IdempotencyRepo:  NewIdempotencyKeyRepository(db),
+		RobotRepo:        NewRobotRepository(db),
+		PlanRepo:         NewDeliveryPlanRepository(db),
 	}
 }
 
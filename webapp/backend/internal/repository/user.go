@@ -6,8 +6,16 @@ import (
 	"errors"
 
 	"backend/internal/model"
+
+	"github.com/go-sql-driver/mysql"
 )
 
+// ユーザー名の一意制約違反
+var ErrDuplicateUserName = errors.New("user name already exists")
+
+// MySQLの一意制約違反のエラー番号
+const mysqlErrDuplicateEntry = 1062
+
 type UserRepository struct {
 	db DBTX
 }
@@ -20,7 +28,7 @@ func NewUserRepository(db DBTX) *UserRepository {
 // ログイン時に使用
 func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*model.User, error) {
 	var user model.User
-	query := "SELECT user_id, password_hash, user_name FROM users WHERE user_name = ?"
+	query := "SELECT user_id, password_hash, user_name, role FROM users WHERE user_name = ?"
 
 	err := r.db.GetContext(ctx, &user, query, userName)
 	if err != nil {
@@ -31,3 +39,47 @@ func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*
 	}
 	return &user, nil
 }
+
+// パスワードハッシュを更新
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE user_id = ?", passwordHash, userID)
+	return err
+}
+
+// ユーザーIDからユーザー情報を取得
+func (r *UserRepository) FindByID(ctx context.Context, userID int) (*model.User, error) {
+	var user model.User
+	query := "SELECT user_id, password_hash, user_name, role FROM users WHERE user_id = ?"
+
+	if err := r.db.GetContext(ctx, &user, query, userID); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ユーザーを作成し、採番されたユーザーIDを返す
+// ユーザー名が既に使われている場合は ErrDuplicateUserName を返す
+func (r *UserRepository) Create(ctx context.Context, userName, passwordHash string) (int, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO users (user_name, password_hash) VALUES (?, ?)", userName, passwordHash)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return 0, ErrDuplicateUserName
+		}
+		return 0, err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(userID), nil
+}
+
+// ロールを更新し、更新件数を返す
+func (r *UserRepository) UpdateRole(ctx context.Context, userID int, role string) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE user_id = ?", role, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -4,17 +4,26 @@ import (
 	"backend/internal/db"
 	"backend/internal/handler"
 	"backend/internal/middleware"
+	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service"
+	"context"
+	"crypto/rand"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
 	"github.com/riandyrn/otelchi"
 )
 
+// ローカル開発・ベンチマーク用の既定のロボットAPIキー
+const defaultRobotAPIKey = "test-robot-key"
+
 type Server struct {
 	Router *chi.Mux
 }
@@ -27,24 +36,41 @@ func NewServer() (*Server, *sqlx.DB, error) {
 
 	store := repository.NewStore(dbConn)
 
-	authService := service.NewAuthService(store)
+	sessionDuration, sessionCleanupInterval := service.GetSessionConfig()
+	tokenConfig, err := tokenConfig()
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	authService := service.NewAuthService(store, sessionDuration, service.GetLoginThrottleConfig(), tokenConfig)
+	authService.StartSessionCleanup(sessionCleanupInterval)
 	orderService := service.NewOrderService(store)
-	productService := service.NewProductService(store)
-	robotService := service.NewRobotService(store)
+	idempotencyKeyTTL, idempotencyKeyCleanupInterval := service.GetIdempotencyKeyConfig()
+	productService := service.NewProductService(store, idempotencyKeyTTL)
+	productService.StartIdempotencyKeyCleanup(idempotencyKeyCleanupInterval)
+	leaseDuration, leaseReapInterval := service.GetLeaseConfig()
+	secretCipher, err := robotSecretCipher()
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	robotService := service.NewRobotService(store, leaseDuration, service.GetSolverBudget(), service.GetRobotKeyRotationOverlap(), secretCipher)
+	robotService.StartLeaseReaper(leaseReapInterval)
 
-	authHandler := handler.NewAuthHandler(authService)
+	authHandler := handler.NewAuthHandler(authService, handler.GetCookieConfig(!isLocalEnv()), handler.GetTrustedProxies())
 	productHandler := handler.NewProductHandler(productService)
 	orderHandler := handler.NewOrderHandler(orderService)
 	robotHandler := handler.NewRobotHandler(robotService)
+	adminHandler := handler.NewAdminHandler(authService)
 
-	userAuthMW := middleware.UserAuthMiddleware(store.SessionRepo)
+	userAuthMW := middleware.UserAuthMiddleware(store.SessionRepo, authService)
 
-	robotAPIKey := os.Getenv("ROBOT_API_KEY")
-	if robotAPIKey == "" {
-		log.Println("Warning: ROBOT_API_KEY is not set. Using default key 'test-robot-key'")
-		robotAPIKey = "test-robot-key"
+	robotAuthConfig, err := robotAuthConfig(store.RobotRepo, secretCipher)
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
 	}
-	robotAuthMW := middleware.RobotAuthMiddleware(robotAPIKey)
+	robotAuthMW := middleware.RobotAuthMiddleware(store.RobotRepo, robotAuthConfig)
 
 	r := chi.NewRouter()
 	r.Use(otelchi.Middleware(
@@ -64,7 +90,7 @@ func NewServer() (*Server, *sqlx.DB, error) {
 		Router: r,
 	}
 
-	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, userAuthMW, robotAuthMW)
+	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, adminHandler, userAuthMW, robotAuthMW)
 
 	return s, dbConn, nil
 }
@@ -74,24 +100,154 @@ func (s *Server) setupRoutes(
 	productHandler *handler.ProductHandler,
 	orderHandler *handler.OrderHandler,
 	robotHandler *handler.RobotHandler,
+	adminHandler *handler.AdminHandler,
 	userAuthMW func(http.Handler) http.Handler,
 	robotAuthMW func(http.Handler) http.Handler,
 ) {
 	s.Router.Post("/api/login", authHandler.Login)
+	s.Router.Post("/api/logout", authHandler.Logout)
+	s.Router.Post("/api/register", authHandler.Register)
+	s.Router.Post("/api/token", authHandler.IssueToken)
+	s.Router.Post("/api/token/refresh", authHandler.RefreshToken)
 
 	s.Router.Route("/api/v1", func(r chi.Router) {
 		r.Use(userAuthMW)
+		r.Get("/me", authHandler.Me)
 		r.Post("/product", productHandler.List)
-		r.Post("/product/post", productHandler.CreateOrders)
 		r.Post("/orders", orderHandler.List)
+		r.Get("/orders/{orderID}", orderHandler.Get)
+		r.Get("/checkouts/{checkoutID}", orderHandler.GetCheckout)
 		r.Get("/image", productHandler.GetImage)
+
+		// 状態を変更するエンドポイントはCSRFトークンを検証する
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.CSRFMiddleware)
+			r.Post("/logout/all", authHandler.LogoutAll)
+			r.Post("/me/password", authHandler.ChangePassword)
+			r.Post("/product/post", productHandler.CreateOrders)
+			r.Post("/orders/{orderID}/cancel", orderHandler.Cancel)
+		})
 	})
 
 	s.Router.Route("/api/robot", func(r chi.Router) {
 		r.Use(robotAuthMW)
 		r.Get("/delivery-plan", robotHandler.GetDeliveryPlan)
+		r.Get("/delivery-plan/preview", robotHandler.PreviewDeliveryPlan)
 		r.Patch("/orders/status", robotHandler.UpdateOrderStatus)
+		r.Patch("/orders/status/batch", robotHandler.UpdateOrderStatuses)
+		r.Post("/orders/lease", robotHandler.ExtendLease)
+		r.Get("/robots", robotHandler.ListRobots)
+		r.Get("/delivery-plans", robotHandler.ListDeliveryPlans)
+		r.Get("/delivery-plans/{planID}", robotHandler.GetDeliveryPlanRecord)
+		r.Post("/keys/rotate", robotHandler.RotateAPIKey)
 	})
+
+	s.Router.Route("/api/admin", func(r chi.Router) {
+		r.Use(userAuthMW)
+
+		// 運用担当者向けのロボット・配送計画の参照
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireRole(model.RoleOperator, model.RoleAdmin))
+			r.Get("/robots", robotHandler.ListRobots)
+			r.Get("/delivery-plans", robotHandler.ListDeliveryPlans)
+			r.Get("/delivery-plans/{planID}", robotHandler.GetDeliveryPlanRecord)
+		})
+
+		// 管理者のみのユーザー管理
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireRole(model.RoleAdmin), middleware.CSRFMiddleware)
+			r.Put("/users/{userID}/role", adminHandler.UpdateUserRole)
+			r.Delete("/users/{userID}/sessions", adminHandler.RevokeUserSessions)
+			r.Post("/robots/{robotID}/keys", robotHandler.RotateRobotAPIKey)
+		})
+	})
+}
+
+// ロボット認証の設定を組み立てる
+// ローカル環境以外では、既定の共有キーが設定・登録されたままなら起動しない
+func robotAuthConfig(robotRepo *repository.RobotRepository, secretCipher *repository.RobotSecretCipher) (middleware.RobotAuthConfig, error) {
+	cfg := middleware.RobotAuthConfig{SecretCipher: secretCipher}
+	cfg.RequireSignature, cfg.MaxClockSkew = middleware.GetRobotSignatureConfig()
+	cfg.SharedAPIKey = os.Getenv("ROBOT_API_KEY")
+
+	if isLocalEnv() {
+		if cfg.SharedAPIKey == "" {
+			log.Printf("Warning: ROBOT_API_KEY is not set. Using default key '%s'", defaultRobotAPIKey)
+			cfg.SharedAPIKey = defaultRobotAPIKey
+		}
+		return cfg, nil
+	}
+
+	if cfg.SharedAPIKey == defaultRobotAPIKey {
+		return cfg, fmt.Errorf("ROBOT_API_KEY must not be the default key outside local mode")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registered, err := robotRepo.HasActiveAPIKey(ctx, defaultRobotAPIKey)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to check robot API keys: %w", err)
+	}
+	if registered {
+		return cfg, fmt.Errorf("the default robot API key is still active; rotate it before starting outside local mode")
+	}
+	if cfg.SharedAPIKey == "" {
+		log.Println("ROBOT_API_KEY is not set. Only per-robot API keys are accepted")
+	}
+	return cfg, nil
+}
+
+// トークン認証の設定を組み立てる
+// 署名鍵が未設定の場合、ローカル環境では起動ごとのランダムな鍵を使い、それ以外では起動しない
+func tokenConfig() (service.TokenConfig, error) {
+	cfg := service.GetTokenConfig()
+	if len(cfg.Secret) > 0 {
+		return cfg, nil
+	}
+	if !isLocalEnv() {
+		return cfg, fmt.Errorf("ACCESS_TOKEN_SECRET must be set outside local mode")
+	}
+
+	secret, err := service.GenerateTokenSecret()
+	if err != nil {
+		return cfg, err
+	}
+	log.Println("Warning: ACCESS_TOKEN_SECRET is not set. Access tokens are signed with a random key and become invalid on restart")
+	cfg.Secret = secret
+	return cfg, nil
+}
+
+// ロボットの署名用の鍵を暗号化する設定を組み立てる
+// 暗号化鍵が未設定の場合、ローカル環境では起動ごとのランダムな鍵を使い、それ以外では起動しない
+func robotSecretCipher() (*repository.RobotSecretCipher, error) {
+	key, err := middleware.GetRobotSigningEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if !isLocalEnv() {
+			return nil, fmt.Errorf("ROBOT_SIGNING_ENCRYPTION_KEY must be set outside local mode")
+		}
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		log.Println("Warning: ROBOT_SIGNING_ENCRYPTION_KEY is not set. Signing secrets of keys issued now cannot be decrypted after restart")
+	}
+	return repository.NewRobotSecretCipher(key)
+}
+
+// ENV（未設定ならGO_ENV）が明示的に local・development の場合のみローカル環境とみなす
+// 未設定の場合は本番と同じく安全側の設定で起動する
+func isLocalEnv() bool {
+	env := os.Getenv("ENV")
+	if env == "" {
+		env = os.Getenv("GO_ENV")
+	}
+	switch strings.ToLower(env) {
+	case "local", "development":
+		return true
+	}
+	return false
 }
 
 func (s *Server) Run() {
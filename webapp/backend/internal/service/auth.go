@@ -5,46 +5,97 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"regexp"
 	"time"
 
+	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 
 	"go.opentelemetry.io/otel"
-	//"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel/trace"
 
 	"crypto/md5"
 	"encoding/hex"
-	"strings"
 )
 
 var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrInvalidPassword = errors.New("invalid password")
 	ErrInternalServer  = errors.New("internal server error")
+	ErrInvalidUserName = errors.New("invalid user name")
+	ErrUserNameTaken   = errors.New("user name is already taken")
+	ErrInvalidRole     = errors.New("invalid role")
 )
 
+// ユーザー名は英数字と . _ - のみ、3〜64文字
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
 type AuthService struct {
-	store *repository.Store
+	store           *repository.Store
+	passwordCost    int
+	sessionDuration time.Duration
+	throttle        *loginThrottle
+	tokens          TokenConfig
 }
 
+// 旧形式のパスワードハッシュ（MD5）を計算する
+// users テーブルに残っている旧ハッシュの照合にのみ使用する
 func GetMD5Hash(text string) string {
 	hash := md5.Sum([]byte(text))
 	return hex.EncodeToString(hash[:])
 }
 
-func NewAuthService(store *repository.Store) *AuthService {
-	return &AuthService{store: store}
+func NewAuthService(store *repository.Store, sessionDuration time.Duration, throttleConfig LoginThrottleConfig, tokenConfig TokenConfig) *AuthService {
+	return &AuthService{
+		store:           store,
+		passwordCost:    GetPasswordHashCost(),
+		sessionDuration: sessionDuration,
+		throttle:        newLoginThrottle(throttleConfig),
+		tokens:          tokenConfig,
+	}
 }
 
-func (s *AuthService) Login(ctx context.Context, userName, password string) (string, time.Time, error) {
+// ユーザー名とパスワードを照合し、セッションを発行する
+// 同じユーザー名やIPからの失敗が続いた場合は照合せずに *LoginLockedError を返す
+func (s *AuthService) Login(ctx context.Context, userName, password, clientIP string) (string, time.Time, error) {
 	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.Login")
 	defer span.End()
 
+	user, err := s.authenticate(ctx, userName, password, clientIP)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
 	var sessionID string
 	var expiresAt time.Time
+	err = utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		sessionID, expiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, s.sessionDuration)
+		if err != nil {
+			log.Printf("[Login] セッション生成失敗: %v", err)
+			return ErrInternalServer
+		}
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Printf("Login successful for UserName '%s', session created.", userName)
+	return sessionID, expiresAt, nil
+}
+
+// ユーザー名とパスワードを照合する（試行回数の制限と旧形式ハッシュの置き換えを含む）
+func (s *AuthService) authenticate(ctx context.Context, userName, password, clientIP string) (*model.User, error) {
+	if wait := s.throttle.retryAfter(userName, clientIP, time.Now()); wait > 0 {
+		log.Printf("[Login] 試行回数制限中(userName: %s, ip: %s, retryAfter: %s)", userName, clientIP, wait)
+		return nil, &LoginLockedError{RetryAfter: wait}
+	}
+
+	var user *model.User
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
-		user, err := s.store.UserRepo.FindByUserName(ctx, userName)
+		var err error
+		user, err = s.store.UserRepo.FindByUserName(ctx, userName)
 		if err != nil {
 			log.Printf("[Login] ユーザー検索失敗(userName: %s): %v", userName, err)
 			if errors.Is(err, sql.ErrNoRows) {
@@ -53,27 +104,219 @@ func (s *AuthService) Login(ctx context.Context, userName, password string) (str
 			return ErrInternalServer
 		}
 
-		pwh := GetMD5Hash(password)
+		ok, needsRehash, err := VerifyPassword(user.PasswordHash, password, s.passwordCost)
+		if err != nil {
+			log.Printf("[Login] パスワード検証エラー(userName: %s): %v", userName, err)
+			trace.SpanFromContext(ctx).RecordError(err)
+			return ErrInternalServer
+		}
+		if !ok {
+			log.Printf("[Login] パスワード検証失敗(userName: %s)", userName)
+			return ErrInvalidPassword
+		}
+
+		// 旧形式のハッシュは照合に成功したタイミングでbcryptに置き換える
+		if needsRehash {
+			if hash, err := HashPassword(password, s.passwordCost); err != nil {
+				log.Printf("[Login] パスワード再ハッシュ失敗(userName: %s): %v", userName, err)
+			} else if err := s.store.UserRepo.UpdatePasswordHash(ctx, user.UserID, hash); err != nil {
+				log.Printf("[Login] パスワードハッシュ更新失敗(userName: %s): %v", userName, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrInvalidPassword) {
+			s.throttle.recordFailure(userName, clientIP, time.Now())
+		}
+		return nil, err
+	}
+	s.throttle.recordSuccess(userName)
+	return user, nil
+}
+
+// セッションを削除してログアウトする
+func (s *AuthService) Logout(ctx context.Context, sessionID string) error {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.Logout")
+	defer span.End()
+
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.SessionRepo.Delete(ctx, sessionID)
+	})
+}
+
+// ユーザーの全セッションとリフレッシュトークンを無効化し、無効化したセッションの件数を返す
+// 本人の「全端末からログアウト」と、管理者による強制ログアウトの両方で使う
+// 存在しないユーザーはErrUserNotFoundを返す
+// 発行済みのアクセストークンは期限（ACCESS_TOKEN_TTL_SECONDS）まで有効
+func (s *AuthService) RevokeUserSessions(ctx context.Context, userID int) (int64, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.RevokeUserSessions")
+	defer span.End()
+
+	var revoked []string
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if _, err := s.store.UserRepo.FindByID(ctx, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		// セッションだけ消えてリフレッシュトークンが残ることのないよう、まとめてコミットする
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			revoked, err = txStore.SessionRepo.DeleteByUserID(ctx, userID)
+			if err != nil {
+				return err
+			}
+			_, err = txStore.RefreshTokenRepo.RevokeByUserID(ctx, userID)
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	// 削除がコミットされてからキャッシュを外す
+	repository.EvictCachedSessions(revoked)
+	log.Printf("Revoked %d sessions for user %d", len(revoked), userID)
+	return int64(len(revoked)), nil
+}
+
+// ユーザーを登録する
+// パスワードはポリシーを検証した上でbcryptで保存する
+func (s *AuthService) Register(ctx context.Context, userName, password string) (*model.User, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.Register")
+	defer span.End()
+
+	if !userNamePattern.MatchString(userName) {
+		return nil, ErrInvalidUserName
+	}
+	if err := ValidatePassword(userName, password); err != nil {
+		return nil, err
+	}
+
+	var user *model.User
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		hash, err := HashPassword(password, s.passwordCost)
+		if err != nil {
+			return err
+		}
+		userID, err := s.store.UserRepo.Create(ctx, userName, hash)
+		if errors.Is(err, repository.ErrDuplicateUserName) {
+			return ErrUserNameTaken
+		}
+		if err != nil {
+			return err
+		}
+		user = &model.User{UserID: userID, UserName: userName, Role: model.RoleCustomer}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("User registered: %s (user_id: %d)", userName, user.UserID)
+	return user, nil
+}
+
+// ユーザー情報を取得
+func (s *AuthService) GetUser(ctx context.Context, userID int) (*model.User, error) {
+	var user *model.User
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = s.store.UserRepo.FindByID(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	})
+	return user, err
+}
+
+// 現在のパスワードを確認した上でパスワードを変更し、
+// currentSessionID 以外のセッションとすべてのリフレッシュトークンを無効化する
+func (s *AuthService) ChangePassword(ctx context.Context, userID int, currentSessionID, currentPassword, newPassword string) error {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.ChangePassword")
+	defer span.End()
 
-		//err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-		if !strings.EqualFold("5f4dcc3b5aa765d61d8327deb882cf99", pwh) {
-		//if err != nil {
-			log.Printf("[Login] パスワード検証失敗: %v", err)
-			span.RecordError(err)
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		ok, _, err := VerifyPassword(user.PasswordHash, currentPassword, s.passwordCost)
+		if err != nil {
+			return err
+		}
+		if !ok {
 			return ErrInvalidPassword
 		}
+		if err := ValidatePassword(user.UserName, newPassword); err != nil {
+			return err
+		}
 
-		sessionDuration := 24 * time.Hour
-		sessionID, expiresAt, err = s.store.SessionRepo.Create(user.UserID, sessionDuration)
+		hash, err := HashPassword(newPassword, s.passwordCost)
 		if err != nil {
-			log.Printf("[Login] セッション生成失敗: %v", err)
-			return ErrInternalServer
+			return err
 		}
+
+		// パスワードだけ変わって他のセッションやリフレッシュトークンが残ることのないよう、まとめてコミットする
+		var revoked []string
+		err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.UserRepo.UpdatePasswordHash(ctx, userID, hash); err != nil {
+				return err
+			}
+			var err error
+			revoked, err = txStore.SessionRepo.DeleteOthers(ctx, userID, currentSessionID)
+			if err != nil {
+				return err
+			}
+			_, err = txStore.RefreshTokenRepo.RevokeByUserID(ctx, userID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// 削除がコミットされてからキャッシュを外す
+		repository.EvictCachedSessions(revoked)
+		log.Printf("Password changed for user %d, revoked %d other sessions", userID, len(revoked))
 		return nil
 	})
+}
+
+// ユーザーのロールを変更する
+// 変更は次のリクエストから反映されるよう、セッションのキャッシュを破棄する
+func (s *AuthService) UpdateUserRole(ctx context.Context, userID int, role string) (*model.User, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.UpdateUserRole")
+	defer span.End()
+
+	switch role {
+	case model.RoleCustomer, model.RoleOperator, model.RoleAdmin:
+	default:
+		return nil, ErrInvalidRole
+	}
+
+	var user *model.User
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = s.store.UserRepo.FindByID(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := s.store.UserRepo.UpdateRole(ctx, userID, role); err != nil {
+			return err
+		}
+		user.Role = role
+		return s.store.SessionRepo.InvalidateUserCache(ctx, userID)
+	})
 	if err != nil {
-		return "", time.Time{}, err
+		return nil, err
 	}
-	log.Printf("Login successful for UserName '%s', session created.", userName)
-	return sessionID, expiresAt, nil
+	log.Printf("Role of user %d changed to %s", userID, role)
+	return user, nil
 }
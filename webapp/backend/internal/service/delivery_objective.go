@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 配送計画の目的関数
+const (
+	// 商品価値の合計のみを最大化する（従来の挙動）
+	DeliveryObjectiveValue = "value"
+	// 商品価値に待ち時間に応じたボーナスを加えた値を最大化する
+	DeliveryObjectiveAge = "age"
+)
+
+var ErrInvalidDeliveryObjective = errors.New("invalid delivery objective")
+
+// 目的関数を正規化し、未定義であればErrInvalidDeliveryObjectiveを返す
+func NormalizeDeliveryPlanOptions(opts model.DeliveryPlanOptions) (model.DeliveryPlanOptions, error) {
+	switch opts.Objective {
+	case "":
+		opts.Objective = DeliveryObjectiveValue
+	case DeliveryObjectiveValue:
+	case DeliveryObjectiveAge:
+		if opts.AgeBonusPerMinute <= 0 {
+			opts.AgeBonusPerMinute = 1
+		}
+	default:
+		return opts, ErrInvalidDeliveryObjective
+	}
+	if opts.AgeBonusPerMinute < 0 || opts.MustIncludeAfter < 0 {
+		return opts, ErrInvalidDeliveryObjective
+	}
+	return opts, nil
+}
+
+// 選択方針に従って配送する注文を選ぶ
+// 待ち時間の長い必須注文を古い順に先に積み、残りの積載量で目的関数を最大化する
+// 返却する計画のTotalValueは目的関数の値ではなく実際の商品価値の合計
+func planDelivery(ctx context.Context, orders []model.Order, robotID string, capacity model.DeliveryCapacity, opts model.DeliveryPlanOptions, budget int64, now time.Time) (model.DeliveryPlan, error) {
+	if opts.Objective != DeliveryObjectiveAge && opts.MustIncludeAfter <= 0 {
+		plan, err := selectOrders(ctx, orders, robotID, capacity, budget)
+		plan.Objective = opts.Objective
+		return plan, err
+	}
+
+	// 必須注文を古い順に積めるだけ積む
+	var mandatory, candidates []model.Order
+	if opts.MustIncludeAfter > 0 {
+		deadline := now.Add(-opts.MustIncludeAfter)
+		var overdue []model.Order
+		for _, o := range orders {
+			if o.CreatedAt.Before(deadline) {
+				overdue = append(overdue, o)
+			} else {
+				candidates = append(candidates, o)
+			}
+		}
+		sort.SliceStable(overdue, func(i, j int) bool {
+			return overdue[i].CreatedAt.Before(overdue[j].CreatedAt)
+		})
+		for _, o := range overdue {
+			if fitsCapacity(o, capacity) {
+				mandatory = append(mandatory, o)
+				capacity = consumeCapacity(o, capacity)
+			} else {
+				candidates = append(candidates, o)
+			}
+		}
+	} else {
+		candidates = orders
+	}
+
+	// 目的関数の値をValueに入れたコピーでナップサックを解く
+	scored := make([]model.Order, len(candidates))
+	byID := make(map[int64]model.Order, len(candidates))
+	for i, o := range candidates {
+		scored[i] = o
+		scored[i].Value = orderScore(o, opts, now)
+		byID[o.OrderID] = o
+	}
+	selected, err := selectOrders(ctx, scored, robotID, capacity, budget)
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+
+	plan := model.DeliveryPlan{
+		RobotID:            robotID,
+		Orders:             make([]model.Order, 0, len(mandatory)+len(selected.Orders)),
+		Objective:          opts.Objective,
+		Solver:             selected.Solver,
+		Exact:              selected.Exact,
+		ApproximationRatio: selected.ApproximationRatio,
+	}
+	plan.Orders = append(plan.Orders, mandatory...)
+	for _, o := range selected.Orders {
+		plan.Orders = append(plan.Orders, byID[o.OrderID])
+	}
+	for _, o := range plan.Orders {
+		plan.TotalWeight += o.Weight
+		plan.TotalVolume += o.Volume
+		plan.TotalValue += o.Value
+	}
+	return plan, nil
+}
+
+// 目的関数における注文の評価値
+func orderScore(o model.Order, opts model.DeliveryPlanOptions, now time.Time) int {
+	if opts.Objective != DeliveryObjectiveAge {
+		return o.Value
+	}
+	waited := int(now.Sub(o.CreatedAt) / time.Minute)
+	if waited < 0 {
+		waited = 0
+	}
+	return o.Value + waited*opts.AgeBonusPerMinute
+}
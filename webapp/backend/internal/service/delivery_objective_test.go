@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func planOrderIDs(plan model.DeliveryPlan) []int64 {
+	ids := make([]int64, len(plan.Orders))
+	for i, o := range plan.Orders {
+		ids[i] = o.OrderID
+	}
+	return ids
+}
+
+func sameOrderIDs(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOrderScore(t *testing.T) {
+	now := time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+	age := model.DeliveryPlanOptions{Objective: DeliveryObjectiveAge, AgeBonusPerMinute: 3}
+
+	tests := []struct {
+		name  string
+		order model.Order
+		opts  model.DeliveryPlanOptions
+		want  int
+	}{
+		{name: "value objective ignores age", order: model.Order{Value: 100, CreatedAt: now.Add(-time.Hour)}, opts: model.DeliveryPlanOptions{Objective: DeliveryObjectiveValue}, want: 100},
+		{name: "bonus per whole minute waited", order: model.Order{Value: 100, CreatedAt: now.Add(-90*time.Minute - 30*time.Second)}, opts: age, want: 100 + 90*3},
+		{name: "no bonus for orders from the future", order: model.Order{Value: 100, CreatedAt: now.Add(time.Minute)}, opts: age, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderScore(tt.order, tt.opts, now); got != tt.want {
+				t.Fatalf("orderScore = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanDeliveryAgeObjectiveFavorsStarvedOrders(t *testing.T) {
+	now := time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+	capacity := model.DeliveryCapacity{Weight: 10, Volume: -1, Items: -1}
+	fresh := model.Order{OrderID: 2, Weight: 10, Value: 200, CreatedAt: now}
+
+	tests := []struct {
+		name   string
+		waited time.Duration
+		opts   model.DeliveryPlanOptions
+		want   int64
+	}{
+		// 価値のみなら新しい高価値の注文を選ぶ
+		{name: "value objective", waited: 120 * time.Minute, opts: model.DeliveryPlanOptions{Objective: DeliveryObjectiveValue}, want: 2},
+		// 100 + 120分×1 = 220 > 200 のため古い低価値の注文が勝つ
+		{name: "long wait outweighs value", waited: 120 * time.Minute, opts: model.DeliveryPlanOptions{Objective: DeliveryObjectiveAge, AgeBonusPerMinute: 1}, want: 1},
+		// 100 + 60分×1 = 160 < 200 のためまだ新しい注文が勝つ
+		{name: "short wait does not", waited: 60 * time.Minute, opts: model.DeliveryPlanOptions{Objective: DeliveryObjectiveAge, AgeBonusPerMinute: 1}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := model.Order{OrderID: 1, Weight: 10, Value: 100, CreatedAt: now.Add(-tt.waited)}
+			plan, err := planDelivery(context.Background(), []model.Order{old, fresh}, "robot-test", capacity, tt.opts, unlimitedBudget, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := planOrderIDs(plan); !sameOrderIDs(got, []int64{tt.want}) {
+				t.Fatalf("selected %v, want [%d]", got, tt.want)
+			}
+			// TotalValue はボーナスを含まない実際の商品価値
+			wantValue := 200
+			if tt.want == 1 {
+				wantValue = 100
+			}
+			if plan.TotalValue != wantValue || plan.Objective != tt.opts.Objective {
+				t.Fatalf("total value %d objective %q, want %d %q", plan.TotalValue, plan.Objective, wantValue, tt.opts.Objective)
+			}
+		})
+	}
+}
+
+func TestPlanDeliveryKeepsMustIncludeOrders(t *testing.T) {
+	now := time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+	orders := []model.Order{
+		{OrderID: 1, Weight: 5, Value: 1000, CreatedAt: now.Add(-time.Minute)},
+		{OrderID: 2, Weight: 4, Value: 1, CreatedAt: now.Add(-90 * time.Minute)},
+		{OrderID: 3, Weight: 4, Value: 1, CreatedAt: now.Add(-3 * time.Hour)},
+		{OrderID: 4, Weight: 4, Value: 1, CreatedAt: now.Add(-2 * time.Hour)},
+		{OrderID: 5, Weight: 5, Value: 900, CreatedAt: now},
+	}
+	opts := model.DeliveryPlanOptions{Objective: DeliveryObjectiveValue, MustIncludeAfter: time.Hour}
+
+	tests := []struct {
+		name     string
+		capacity model.DeliveryCapacity
+		want     []int64
+	}{
+		// 価値だけなら 1 と 5 を選ぶが、1時間以上待っている注文が古い順に優先される
+		{name: "weight limit", capacity: model.DeliveryCapacity{Weight: 10, Volume: -1, Items: -1}, want: []int64{3, 4}},
+		// 必須注文で埋まらなかった積載量は目的関数で埋める
+		{name: "room left after mandatory", capacity: model.DeliveryCapacity{Weight: 17, Volume: -1, Items: -1}, want: []int64{3, 4, 2, 1}},
+		// 個数制限でも必須注文を古い順に残す
+		{name: "item limit", capacity: model.DeliveryCapacity{Weight: 100, Volume: -1, Items: 1}, want: []int64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planDelivery(context.Background(), orders, "robot-test", tt.capacity, opts, unlimitedBudget, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := planOrderIDs(plan); !sameOrderIDs(got, tt.want) {
+				t.Fatalf("selected %v, want %v", got, tt.want)
+			}
+			weight, value := 0, 0
+			for _, o := range plan.Orders {
+				weight += o.Weight
+				value += o.Value
+			}
+			if weight > tt.capacity.Weight || plan.TotalWeight != weight || plan.TotalValue != value {
+				t.Fatalf("totals (%d, %d) for orders (%d, %d) with capacity %d", plan.TotalWeight, plan.TotalValue, weight, value, tt.capacity.Weight)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/service/utils"
+)
+
+// 同じ冪等キーが異なる内容のリクエストに使われた
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+
+// 冪等キーの設定を環境変数から取得
+func GetIdempotencyKeyConfig() (keyTTL, cleanupInterval time.Duration) {
+	// デフォルト値
+	keyTTL = 24 * time.Hour
+	cleanupInterval = 10 * time.Minute
+
+	if val := os.Getenv("IDEMPOTENCY_KEY_TTL_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			keyTTL = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if val := os.Getenv("IDEMPOTENCY_KEY_CLEANUP_INTERVAL_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			cleanupInterval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return keyTTL, cleanupInterval
+}
+
+// 注文リクエストの内容のハッシュ（同じキーの再利用が同一リクエストかの判定に使う）
+// JSONの空白やキーの順序の違いは同一とみなすよう、デコード後の値から計算する
+func hashOrderRequest(items []model.RequestItem) (string, error) {
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// 冪等キーに保存した最初の注文作成の結果を返す
+func (s *ProductService) replayCreateOrders(ctx context.Context, userID int, key, requestHash string) (*model.Checkout, []string, error) {
+	var record *model.OrderIdempotencyKey
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		record, err = s.store.IdempotencyRepo.Find(ctx, userID, key)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if record.RequestHash != requestHash {
+		return nil, nil, ErrIdempotencyKeyReused
+	}
+
+	var checkout *model.Checkout
+	if record.CheckoutID != 0 {
+		checkout = &model.Checkout{CheckoutID: record.CheckoutID, UserID: userID, TotalValue: record.TotalValue}
+	}
+	log.Printf("Replayed order creation for user %d (idempotency key reused)", userID)
+	return checkout, record.OrderIDs, nil
+}
+
+// 期限切れの冪等キーの削除をバックグラウンドで定期実行する
+func (s *ProductService) StartIdempotencyKeyCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := utils.WithTimeout(context.Background(), func(ctx context.Context) error {
+				deleted, err := s.store.IdempotencyRepo.DeleteExpired(ctx, time.Now())
+				if err != nil {
+					return err
+				}
+				if deleted > 0 {
+					log.Printf("Deleted %d expired idempotency keys", deleted)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("Failed to delete expired idempotency keys: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"backend/internal/model"
+)
+
+// 配送計画の解法
+const (
+	SolverExact  = "exact"
+	SolverFPTAS  = "fptas"
+	SolverGreedy = "greedy"
+)
+
+// 厳密DPを使う表サイズ(注文数×圧縮後の積載量)の既定上限
+// chooseビットセットで約64MiBに相当する
+const defaultSolverBudget int64 = 1 << 29
+
+// FPTASで試す誤差εの下限。これで表が収まらなければεを倍にしていく
+const minFPTASEpsilon = 0.01
+
+// 厳密DPの表サイズ上限を環境変数から取得
+func GetSolverBudget() int64 {
+	if val := os.Getenv("DELIVERY_SOLVER_MAX_CELLS"); val != "" {
+		if cells, err := strconv.ParseInt(val, 10, 64); err == nil && cells > 0 {
+			return cells
+		}
+	}
+	return defaultSolverBudget
+}
+
+// 厳密DPが使えない規模での近似解法
+// 価値をスケーリングしたFPTAS(保証比率 1-ε)が budget に収まればそれを使い、
+// 収まらなければ密度順の貪欲法(保証比率 1/2)を使う
+// 報告する比率は理論保証とLP緩和の上界から求めた事後保証の大きい方
+func selectOrdersApprox(ctx context.Context, orders []model.Order, robotID string, capacity int, budget int64) (model.DeliveryPlan, error) {
+	// 積めない注文と価値のない注文は候補から外す
+	idx := make([]int, 0, len(orders))
+	for i, o := range orders {
+		if o.Weight <= capacity && o.Value > 0 {
+			idx = append(idx, i)
+		}
+	}
+
+	var (
+		selected []int
+		solver   string
+		ratio    float64
+		err      error
+	)
+	for eps := minFPTASEpsilon; eps < 0.5; eps *= 2 {
+		selected, ratio, err = fptasKnapsack(ctx, orders, idx, capacity, eps, budget)
+		if err != nil {
+			return model.DeliveryPlan{}, err
+		}
+		if selected != nil {
+			solver = SolverFPTAS
+			break
+		}
+	}
+	if selected == nil {
+		selected = greedyKnapsack(orders, idx, capacity)
+		solver, ratio = SolverGreedy, 0.5
+	}
+
+	sort.Ints(selected)
+	plan := model.DeliveryPlan{
+		RobotID: robotID,
+		Orders:  make([]model.Order, 0, len(selected)),
+		Solver:  solver,
+	}
+	for _, i := range selected {
+		plan.Orders = append(plan.Orders, orders[i])
+		plan.TotalWeight += orders[i].Weight
+		plan.TotalValue += orders[i].Value
+	}
+
+	if bound := fractionalUpperBound(orders, idx, capacity); bound > 0 {
+		ratio = math.Max(ratio, float64(plan.TotalValue)/bound)
+	}
+	if ratio >= 1 {
+		ratio = 1
+		plan.Exact = true
+	}
+	plan.ApproximationRatio = ratio
+	return plan, nil
+}
+
+// 価値を K = ε·vmax/n でスケーリングし、価値ごとの最小重量をDPで求める
+// 表サイズが budget を超える場合は (nil, 0, nil) を返す
+func fptasKnapsack(ctx context.Context, orders []model.Order, idx []int, capacity int, eps float64, budget int64) ([]int, float64, error) {
+	n := len(idx)
+	if n == 0 {
+		return []int{}, 1, nil
+	}
+
+	vmax := 0
+	for _, i := range idx {
+		if orders[i].Value > vmax {
+			vmax = orders[i].Value
+		}
+	}
+	K := eps * float64(vmax) / float64(n)
+	ratio := 1 - eps
+	if K <= 1 {
+		// スケーリング不要なら価値DPは厳密解になる
+		K, ratio = 1, 1
+	}
+
+	scaled := make([]int, n)
+	sumV := int64(0)
+	for j, i := range idx {
+		scaled[j] = int(float64(orders[i].Value) / K)
+		sumV += int64(scaled[j])
+	}
+	if int64(n)*(sumV+1) > budget {
+		return nil, 0, nil
+	}
+	V := int(sumV)
+
+	const inf = math.MaxInt64
+	minW := make([]int64, V+1)
+	for v := 1; v <= V; v++ {
+		minW[v] = inf
+	}
+	words := V>>6 + 1
+	choose := make([][]uint64, n)
+	for j := range choose {
+		choose[j] = make([]uint64, words)
+	}
+
+	const checkEvery = 8192
+	steps := 0
+	reach := 0
+	for j, i := range idx {
+		vj, wj := scaled[j], int64(orders[i].Weight)
+		if vj == 0 {
+			continue
+		}
+		for v := reach + vj; v >= vj; v-- {
+			steps++
+			if steps%checkEvery == 0 {
+				select {
+				case <-ctx.Done():
+					return nil, 0, ctx.Err()
+				default:
+				}
+			}
+			prev := minW[v-vj]
+			if prev == inf || prev+wj > int64(capacity) {
+				continue
+			}
+			if nw := prev + wj; nw < minW[v] {
+				minW[v] = nw
+				choose[j][v>>6] |= uint64(1) << uint(v&63)
+			}
+		}
+		reach += vj
+	}
+
+	best := 0
+	for v := V; v >= 0; v-- {
+		if minW[v] != inf {
+			best = v
+			break
+		}
+	}
+
+	selected := make([]int, 0)
+	v := best
+	for j := n - 1; j >= 0 && v > 0; j-- {
+		if (choose[j][v>>6]>>uint(v&63))&1 == 1 {
+			selected = append(selected, idx[j])
+			v -= scaled[j]
+		}
+	}
+	return selected, ratio, nil
+}
+
+// 価値/重量の密度順に積めるだけ積む
+// 最も価値の高い単品と比較して良い方を返すことで最適値の1/2以上を保証する
+func greedyKnapsack(orders []model.Order, idx []int, capacity int) []int {
+	sorted := sortByDensity(orders, idx)
+
+	selected := make([]int, 0)
+	remaining, value := capacity, 0
+	for _, i := range sorted {
+		if orders[i].Weight <= remaining {
+			selected = append(selected, i)
+			remaining -= orders[i].Weight
+			value += orders[i].Value
+		}
+	}
+
+	bestSingle := -1
+	for _, i := range idx {
+		if bestSingle < 0 || orders[i].Value > orders[bestSingle].Value {
+			bestSingle = i
+		}
+	}
+	if bestSingle >= 0 && orders[bestSingle].Value > value {
+		return []int{bestSingle}
+	}
+	return selected
+}
+
+// 分数ナップサック(LP緩和)の最適値。0-1ナップサックの最適値の上界になる
+func fractionalUpperBound(orders []model.Order, idx []int, capacity int) float64 {
+	bound, remaining := 0.0, capacity
+	for _, i := range sortByDensity(orders, idx) {
+		if orders[i].Weight <= remaining {
+			bound += float64(orders[i].Value)
+			remaining -= orders[i].Weight
+			continue
+		}
+		bound += float64(orders[i].Value) * float64(remaining) / float64(orders[i].Weight)
+		break
+	}
+	return bound
+}
+
+// 価値/重量の密度が高い順に並べた添字を返す（重量0の注文は先頭）
+func sortByDensity(orders []model.Order, idx []int) []int {
+	sorted := make([]int, len(idx))
+	copy(sorted, idx)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		oa, ob := orders[sorted[a]], orders[sorted[b]]
+		// va/wa > vb/wb を乗算で比較（重量0は無限大の密度として扱う）
+		return uint64(oa.Value)*uint64(ob.Weight) > uint64(ob.Value)*uint64(oa.Weight)
+	})
+	return sorted
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"backend/internal/model"
+)
+
+// 厳密DPが必ず使われる十分大きな表サイズ上限
+const unlimitedBudget int64 = 1 << 40
+
+// 全組み合わせを調べて最適値を求める（小さな入力の検証用）
+func bruteForceKnapsack(orders []model.Order, capacity int) int {
+	best := 0
+	for mask := 0; mask < 1<<len(orders); mask++ {
+		weight, value := 0, 0
+		for i, o := range orders {
+			if mask&(1<<i) != 0 {
+				weight += o.Weight
+				value += o.Value
+			}
+		}
+		if weight <= capacity && value > best {
+			best = value
+		}
+	}
+	return best
+}
+
+func randomOrders(rng *rand.Rand, n int) []model.Order {
+	orders := make([]model.Order, n)
+	for i := range orders {
+		orders[i] = model.Order{
+			OrderID: int64(i + 1),
+			Weight:  1 + rng.Intn(40),
+			Value:   rng.Intn(5000),
+		}
+	}
+	return orders
+}
+
+// 計画が積載量を守り、合計値が注文の内容と一致していることを確認する
+func checkPlanConsistency(t *testing.T, plan model.DeliveryPlan, capacity int) {
+	t.Helper()
+	weight, value := 0, 0
+	seen := make(map[int64]bool, len(plan.Orders))
+	for _, o := range plan.Orders {
+		if seen[o.OrderID] {
+			t.Fatalf("order %d selected twice", o.OrderID)
+		}
+		seen[o.OrderID] = true
+		weight += o.Weight
+		value += o.Value
+	}
+	if weight > capacity {
+		t.Fatalf("total weight %d exceeds capacity %d", weight, capacity)
+	}
+	if weight != plan.TotalWeight || value != plan.TotalValue {
+		t.Fatalf("reported totals (%d, %d) do not match orders (%d, %d)", plan.TotalWeight, plan.TotalValue, weight, value)
+	}
+}
+
+func TestSelectOrdersForDeliveryIsExact(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		orders := randomOrders(rng, 1+rng.Intn(12))
+		capacity := 1 + rng.Intn(150)
+
+		plan, err := selectOrdersForDelivery(context.Background(), orders, "robot-test", capacity, unlimitedBudget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPlanConsistency(t, plan, capacity)
+		if want := bruteForceKnapsack(orders, capacity); plan.TotalValue != want {
+			t.Fatalf("trial %d: exact DP value %d, want %d", trial, plan.TotalValue, want)
+		}
+		if !plan.Exact || plan.ApproximationRatio != 1 || plan.Solver != SolverExact {
+			t.Fatalf("trial %d: exact DP reported exact=%v ratio=%v solver=%s", trial, plan.Exact, plan.ApproximationRatio, plan.Solver)
+		}
+	}
+}
+
+func TestFPTASKnapsackMeetsRatio(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for trial := 0; trial < 200; trial++ {
+		orders := randomOrders(rng, 1+rng.Intn(12))
+		capacity := 1 + rng.Intn(150)
+		optimum := bruteForceKnapsack(orders, capacity)
+
+		idx := make([]int, 0, len(orders))
+		for i, o := range orders {
+			if o.Weight <= capacity && o.Value > 0 {
+				idx = append(idx, i)
+			}
+		}
+		for _, eps := range []float64{0.01, 0.1, 0.3} {
+			selected, ratio, err := fptasKnapsack(context.Background(), orders, idx, capacity, eps, unlimitedBudget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ratio < 1-eps {
+				t.Fatalf("trial %d eps %v: reported ratio %v below 1-eps", trial, eps, ratio)
+			}
+			weight, value := 0, 0
+			for _, i := range selected {
+				weight += orders[i].Weight
+				value += orders[i].Value
+			}
+			if weight > capacity {
+				t.Fatalf("trial %d eps %v: weight %d exceeds capacity %d", trial, eps, weight, capacity)
+			}
+			if float64(value) < ratio*float64(optimum)-1e-9 {
+				t.Fatalf("trial %d eps %v: value %d < %v × optimum %d", trial, eps, value, ratio, optimum)
+			}
+		}
+	}
+}
+
+func TestSelectOrdersApproxReportsRatio(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for trial := 0; trial < 200; trial++ {
+		orders := randomOrders(rng, 1+rng.Intn(12))
+		capacity := 1 + rng.Intn(150)
+		optimum := bruteForceKnapsack(orders, capacity)
+		candidates := 0
+		for _, o := range orders {
+			if o.Weight <= capacity && o.Value > 0 {
+				candidates++
+			}
+		}
+
+		// budget=1 ではFPTASの表も収まらないため貪欲法になる（候補がなければFPTASの空解）
+		for _, tc := range []struct {
+			budget int64
+			solver string
+		}{
+			{budget: unlimitedBudget, solver: SolverFPTAS},
+			{budget: 1, solver: SolverGreedy},
+		} {
+			plan, err := selectOrdersApprox(context.Background(), orders, "robot-test", capacity, tc.budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPlanConsistency(t, plan, capacity)
+			if candidates > 0 && plan.Solver != tc.solver {
+				t.Fatalf("trial %d: solver %s, want %s", trial, plan.Solver, tc.solver)
+			}
+			if plan.ApproximationRatio <= 0 || plan.ApproximationRatio > 1 {
+				t.Fatalf("trial %d %s: ratio %v out of range", trial, plan.Solver, plan.ApproximationRatio)
+			}
+			if plan.Solver == SolverGreedy && plan.ApproximationRatio < 0.5 {
+				t.Fatalf("trial %d: greedy ratio %v below guarantee 0.5", trial, plan.ApproximationRatio)
+			}
+			if float64(plan.TotalValue) < plan.ApproximationRatio*float64(optimum)-1e-9 {
+				t.Fatalf("trial %d %s: value %d < %v × optimum %d", trial, plan.Solver, plan.TotalValue, plan.ApproximationRatio, optimum)
+			}
+			if plan.Exact != (plan.ApproximationRatio == 1) {
+				t.Fatalf("trial %d %s: exact=%v with ratio %v", trial, plan.Solver, plan.Exact, plan.ApproximationRatio)
+			}
+			if plan.Exact && plan.TotalValue != optimum {
+				t.Fatalf("trial %d %s: reported exact but value %d, optimum %d", trial, plan.Solver, plan.TotalValue, optimum)
+			}
+		}
+	}
+}
+
+func TestSelectOrdersFallsBackToApprox(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 7, Value: 10},
+		{OrderID: 2, Weight: 11, Value: 17},
+		{OrderID: 3, Weight: 13, Value: 19},
+		{OrderID: 4, Weight: 17, Value: 30},
+	}
+	capacity := 30
+
+	// 表サイズ n×(W+1) が上限を超えると近似解法に切り替わる
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot-test", capacity, int64(len(orders)*capacity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Solver == SolverExact {
+		t.Fatalf("expected approximate solver for a small budget, got %s", plan.Solver)
+	}
+	checkPlanConsistency(t, plan, capacity)
+	if optimum := bruteForceKnapsack(orders, capacity); float64(plan.TotalValue) < plan.ApproximationRatio*float64(optimum) {
+		t.Fatalf("value %d < %v × optimum %d", plan.TotalValue, plan.ApproximationRatio, optimum)
+	}
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"backend/internal/model"
+)
+
+// 積載制限に応じて解法を選び、配送する注文を選ぶ
+// 重量のみの制限なら従来の1次元ナップサック、容積や個数の制限があれば多次元ナップサックを解く
+func selectOrders(ctx context.Context, orders []model.Order, robotID string, capacity model.DeliveryCapacity, budget int64) (model.DeliveryPlan, error) {
+	var plan model.DeliveryPlan
+	var err error
+	if capacity.Volume < 0 && capacity.Items < 0 {
+		plan, err = selectOrdersForDelivery(ctx, orders, robotID, capacity.Weight, budget)
+	} else {
+		plan, err = selectOrdersMulti(ctx, orders, robotID, capacity, budget)
+	}
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	for _, o := range plan.Orders {
+		plan.TotalVolume += o.Volume
+	}
+	return plan, nil
+}
+
+// 注文が残りの積載制限に収まるか
+func fitsCapacity(o model.Order, remaining model.DeliveryCapacity) bool {
+	return o.Weight <= remaining.Weight &&
+		(remaining.Volume < 0 || o.Volume <= remaining.Volume) &&
+		(remaining.Items < 0 || remaining.Items >= 1)
+}
+
+// 積載制限から注文1件分を差し引く
+func consumeCapacity(o model.Order, remaining model.DeliveryCapacity) model.DeliveryCapacity {
+	remaining.Weight -= o.Weight
+	if remaining.Volume >= 0 {
+		remaining.Volume -= o.Volume
+	}
+	if remaining.Items >= 0 {
+		remaining.Items--
+	}
+	return remaining
+}
+
+// 多次元ナップサック（重量・容積・個数）
+// 状態数×注文数が budget に収まれば厳密DP、収まらなければ正規化した密度による貪欲法を使う
+func selectOrdersMulti(ctx context.Context, orders []model.Order, robotID string, capacity model.DeliveryCapacity, budget int64) (model.DeliveryPlan, error) {
+	empty := model.DeliveryPlan{
+		RobotID:            robotID,
+		Orders:             []model.Order{},
+		Solver:             SolverExact,
+		Exact:              true,
+		ApproximationRatio: 1,
+	}
+	if capacity.Weight <= 0 {
+		return empty, nil
+	}
+
+	// 単体で積める注文だけを候補にする
+	idx := make([]int, 0, len(orders))
+	for i, o := range orders {
+		if o.Value >= 0 && fitsCapacity(o, capacity) {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		return empty, nil
+	}
+
+	// 早期：全部載る
+	remaining := capacity
+	allFit := true
+	for _, i := range idx {
+		if !fitsCapacity(orders[i], remaining) {
+			allFit = false
+			break
+		}
+		remaining = consumeCapacity(orders[i], remaining)
+	}
+	if allFit {
+		return multiPlan(orders, idx, robotID, SolverExact, 1), nil
+	}
+
+	selected, ok, err := exactMultiKnapsack(ctx, orders, idx, capacity, budget)
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	if ok {
+		return multiPlan(orders, selected, robotID, SolverExact, 1), nil
+	}
+
+	selected = greedyMultiKnapsack(orders, idx, capacity)
+	plan := multiPlan(orders, selected, robotID, SolverGreedy, 0)
+	// 多次元の貪欲法には定数比の保証がないため、各次元のLP緩和の最小値を上界として事後保証を求める
+	bound := fractionalUpperBound(orders, idx, capacity.Weight)
+	if capacity.Volume >= 0 {
+		bound = math.Min(bound, fractionalVolumeUpperBound(orders, idx, capacity.Volume))
+	}
+	if capacity.Items >= 0 {
+		bound = math.Min(bound, topValuesBound(orders, idx, capacity.Items))
+	}
+	if bound > 0 {
+		plan.ApproximationRatio = math.Min(1, float64(plan.TotalValue)/bound)
+	} else {
+		plan.ApproximationRatio = 1
+	}
+	plan.Exact = plan.ApproximationRatio >= 1
+	return plan, nil
+}
+
+// 選択した注文から配送計画を組み立てる（入力順）
+func multiPlan(orders []model.Order, selected []int, robotID, solver string, ratio float64) model.DeliveryPlan {
+	sort.Ints(selected)
+	plan := model.DeliveryPlan{
+		RobotID:            robotID,
+		Orders:             make([]model.Order, 0, len(selected)),
+		Solver:             solver,
+		Exact:              ratio >= 1,
+		ApproximationRatio: ratio,
+	}
+	for _, i := range selected {
+		plan.Orders = append(plan.Orders, orders[i])
+		plan.TotalWeight += orders[i].Weight
+		plan.TotalValue += orders[i].Value
+	}
+	return plan
+}
+
+// 重量・容積・個数を混合基数で1次元に並べた状態上の0-1ナップサックDP
+// 各次元はGCDで圧縮し、状態数×注文数が budget を超える場合は ok=false を返す
+func exactMultiKnapsack(ctx context.Context, orders []model.Order, idx []int, capacity model.DeliveryCapacity, budget int64) ([]int, bool, error) {
+	n := len(idx)
+
+	// 次元ごとの消費量と上限（制限のない次元はサイズ1として潰す）
+	gw, gv := 0, 0
+	for _, i := range idx {
+		gw = gcd(gw, orders[i].Weight)
+		if capacity.Volume >= 0 {
+			gv = gcd(gv, orders[i].Volume)
+		}
+	}
+	if gw == 0 {
+		gw = 1
+	}
+	if gv == 0 {
+		gv = 1
+	}
+	W := capacity.Weight / gw
+	V := 0
+	if capacity.Volume >= 0 {
+		V = capacity.Volume / gv
+	}
+	C := 0
+	if capacity.Items >= 0 {
+		C = capacity.Items
+		if C > n {
+			C = n
+		}
+	}
+
+	cells := int64(W+1) * int64(V+1) * int64(C+1)
+	if cells > budget || int64(n)*cells > budget {
+		return nil, false, nil
+	}
+	stride1, stride2 := W+1, (W+1)*(V+1)
+	size := int(cells)
+
+	dw := make([]int, n)
+	dv := make([]int, n)
+	dc := make([]int, n)
+	for j, i := range idx {
+		dw[j] = orders[i].Weight / gw
+		if capacity.Volume >= 0 {
+			dv[j] = orders[i].Volume / gv
+		}
+		if capacity.Items >= 0 {
+			dc[j] = 1
+		}
+	}
+
+	// dp[s] は状態 s をちょうど消費する組み合わせの最大価値（-1 は到達不能）
+	dp := make([]int, size)
+	for s := 1; s < size; s++ {
+		dp[s] = -1
+	}
+	words := size>>6 + 1
+	choose := make([][]uint64, n)
+	for j := range choose {
+		choose[j] = make([]uint64, words)
+	}
+
+	const checkEvery = 8192
+	steps := 0
+	var always []int
+	for j, i := range idx {
+		delta := dw[j] + dv[j]*stride1 + dc[j]*stride2
+		if delta == 0 {
+			// どの次元も消費しない注文は常に積む
+			always = append(always, i)
+			continue
+		}
+		val := orders[i].Value
+		for s := size - 1; s >= delta; s-- {
+			steps++
+			if steps%checkEvery == 0 {
+				select {
+				case <-ctx.Done():
+					return nil, false, ctx.Err()
+				default:
+				}
+			}
+			w, v, c := s%stride1, (s/stride1)%(V+1), s/stride2
+			if w < dw[j] || v < dv[j] || c < dc[j] {
+				continue
+			}
+			prev := dp[s-delta]
+			if prev < 0 {
+				continue
+			}
+			if nv := prev + val; nv > dp[s] {
+				dp[s] = nv
+				choose[j][s>>6] |= uint64(1) << uint(s&63)
+			}
+		}
+	}
+
+	best := 0
+	for s := size - 1; s >= 0; s-- {
+		if dp[s] > dp[best] {
+			best = s
+		}
+	}
+
+	selected := append([]int{}, always...)
+	s := best
+	for j := n - 1; j >= 0 && s > 0; j-- {
+		if (choose[j][s>>6]>>uint(s&63))&1 == 1 {
+			selected = append(selected, idx[j])
+			s -= dw[j] + dv[j]*stride1 + dc[j]*stride2
+		}
+	}
+	return selected, true, nil
+}
+
+// 各次元の消費量を上限で正規化した合計あたりの価値が高い順に積めるだけ積む
+// 最も価値の高い単品と比較して良い方を返す
+func greedyMultiKnapsack(orders []model.Order, idx []int, capacity model.DeliveryCapacity) []int {
+	load := func(o model.Order) float64 {
+		l := float64(o.Weight) / float64(capacity.Weight)
+		if capacity.Volume > 0 {
+			l += float64(o.Volume) / float64(capacity.Volume)
+		}
+		if capacity.Items > 0 {
+			l += 1 / float64(capacity.Items)
+		}
+		return l
+	}
+	sorted := make([]int, len(idx))
+	copy(sorted, idx)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		oa, ob := orders[sorted[a]], orders[sorted[b]]
+		return float64(oa.Value)*load(ob) > float64(ob.Value)*load(oa)
+	})
+
+	selected := make([]int, 0)
+	remaining, value := capacity, 0
+	for _, i := range sorted {
+		if fitsCapacity(orders[i], remaining) {
+			selected = append(selected, i)
+			remaining = consumeCapacity(orders[i], remaining)
+			value += orders[i].Value
+		}
+	}
+
+	bestSingle := -1
+	for _, i := range idx {
+		if bestSingle < 0 || orders[i].Value > orders[bestSingle].Value {
+			bestSingle = i
+		}
+	}
+	if bestSingle >= 0 && orders[bestSingle].Value > value {
+		return []int{bestSingle}
+	}
+	return selected
+}
+
+// 容積についての分数ナップサックの最適値（上界）
+func fractionalVolumeUpperBound(orders []model.Order, idx []int, capacity int) float64 {
+	byVolume := make([]model.Order, len(orders))
+	for i, o := range orders {
+		byVolume[i] = o
+		byVolume[i].Weight = o.Volume
+	}
+	return fractionalUpperBound(byVolume, idx, capacity)
+}
+
+// 個数制限のみを考えた場合の最適値（価値の上位 k 件の合計、上界）
+func topValuesBound(orders []model.Order, idx []int, k int) float64 {
+	values := make([]int, len(idx))
+	for j, i := range idx {
+		values[j] = orders[i].Value
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(values)))
+	if k > len(values) {
+		k = len(values)
+	}
+	sum := 0.0
+	for _, v := range values[:k] {
+		sum += float64(v)
+	}
+	return sum
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"backend/internal/model"
+)
+
+// 全組み合わせを調べて重量・容積・個数の制限下での最適値を求める（小さな入力の検証用）
+func bruteForceMultiKnapsack(orders []model.Order, capacity model.DeliveryCapacity) int {
+	best := 0
+	for mask := 0; mask < 1<<len(orders); mask++ {
+		weight, volume, items, value := 0, 0, 0, 0
+		for i, o := range orders {
+			if mask&(1<<i) != 0 {
+				weight += o.Weight
+				volume += o.Volume
+				items++
+				value += o.Value
+			}
+		}
+		if weight > capacity.Weight ||
+			(capacity.Volume >= 0 && volume > capacity.Volume) ||
+			(capacity.Items >= 0 && items > capacity.Items) {
+			continue
+		}
+		if value > best {
+			best = value
+		}
+	}
+	return best
+}
+
+// 重量・容積が0の注文も一定の割合で含める
+func randomMultiOrders(rng *rand.Rand, n int) []model.Order {
+	orders := make([]model.Order, n)
+	for i := range orders {
+		orders[i] = model.Order{
+			OrderID: int64(i + 1),
+			Weight:  rng.Intn(30),
+			Volume:  rng.Intn(20),
+			Value:   rng.Intn(5000),
+		}
+	}
+	return orders
+}
+
+// 計画が全ての積載制限を守り、合計値が注文の内容と一致していることを確認する
+func checkMultiPlanConsistency(t *testing.T, plan model.DeliveryPlan, capacity model.DeliveryCapacity) {
+	t.Helper()
+	checkPlanConsistency(t, plan, capacity.Weight)
+	volume := 0
+	for _, o := range plan.Orders {
+		volume += o.Volume
+	}
+	if capacity.Volume >= 0 && volume > capacity.Volume {
+		t.Fatalf("total volume %d exceeds capacity %d", volume, capacity.Volume)
+	}
+	if capacity.Items >= 0 && len(plan.Orders) > capacity.Items {
+		t.Fatalf("%d orders exceed item limit %d", len(plan.Orders), capacity.Items)
+	}
+	if volume != plan.TotalVolume {
+		t.Fatalf("reported volume %d does not match orders %d", plan.TotalVolume, volume)
+	}
+}
+
+// 制限する次元の組み合わせ（負の値はその次元を制限しない）
+var multiCapacityCases = []struct {
+	name        string
+	limitVolume bool
+	limitItems  bool
+}{
+	{name: "weight+volume", limitVolume: true},
+	{name: "weight+items", limitItems: true},
+	{name: "weight+volume+items", limitVolume: true, limitItems: true},
+}
+
+func randomMultiCapacity(rng *rand.Rand, limitVolume, limitItems bool) model.DeliveryCapacity {
+	capacity := model.DeliveryCapacity{Weight: 1 + rng.Intn(80), Volume: -1, Items: -1}
+	if limitVolume {
+		capacity.Volume = rng.Intn(50)
+	}
+	if limitItems {
+		capacity.Items = rng.Intn(6)
+	}
+	return capacity
+}
+
+func TestSelectOrdersMultiIsExact(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for _, tc := range multiCapacityCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for trial := 0; trial < 200; trial++ {
+				orders := randomMultiOrders(rng, 1+rng.Intn(10))
+				capacity := randomMultiCapacity(rng, tc.limitVolume, tc.limitItems)
+
+				plan, err := selectOrders(context.Background(), orders, "robot-test", capacity, unlimitedBudget)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkMultiPlanConsistency(t, plan, capacity)
+				if want := bruteForceMultiKnapsack(orders, capacity); plan.TotalValue != want {
+					t.Fatalf("trial %d capacity %+v: value %d, want %d", trial, capacity, plan.TotalValue, want)
+				}
+				if !plan.Exact || plan.ApproximationRatio != 1 || plan.Solver != SolverExact {
+					t.Fatalf("trial %d: exact DP reported exact=%v ratio=%v solver=%s", trial, plan.Exact, plan.ApproximationRatio, plan.Solver)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectOrdersMultiAlwaysIncludesZeroSizeOrders(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 6, Volume: 4, Value: 10},
+		{OrderID: 2, Weight: 0, Volume: 0, Value: 3},
+		{OrderID: 3, Weight: 5, Volume: 5, Value: 9},
+		{OrderID: 4, Weight: 0, Volume: 0, Value: 0},
+		{OrderID: 5, Weight: 4, Volume: 6, Value: 8},
+	}
+	// どの次元も消費しない注文は、積載量が足りず他の注文を絞る場合でも必ず積まれる
+	for _, capacity := range []model.DeliveryCapacity{
+		{Weight: 10, Volume: 10, Items: -1},
+		{Weight: 10, Volume: -1, Items: -1},
+	} {
+		plan, err := selectOrdersMulti(context.Background(), orders, "robot-test", capacity, unlimitedBudget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range plan.Orders {
+			plan.TotalVolume += o.Volume
+		}
+		checkMultiPlanConsistency(t, plan, capacity)
+		included := make(map[int64]bool, len(plan.Orders))
+		for _, o := range plan.Orders {
+			included[o.OrderID] = true
+		}
+		if !included[2] || !included[4] {
+			t.Fatalf("capacity %+v: zero-size orders missing from plan %+v", capacity, plan.Orders)
+		}
+		if want := bruteForceMultiKnapsack(orders, capacity); plan.TotalValue != want {
+			t.Fatalf("capacity %+v: value %d, want %d", capacity, plan.TotalValue, want)
+		}
+	}
+}
+
+func TestSelectOrdersMultiFallsBackToGreedy(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	for _, tc := range multiCapacityCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for trial := 0; trial < 200; trial++ {
+				orders := randomMultiOrders(rng, 1+rng.Intn(10))
+				capacity := randomMultiCapacity(rng, tc.limitVolume, tc.limitItems)
+				optimum := bruteForceMultiKnapsack(orders, capacity)
+
+				// budget=1 では状態表が収まらないため、全部載る場合を除いて貪欲法になる
+				plan, err := selectOrders(context.Background(), orders, "robot-test", capacity, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkMultiPlanConsistency(t, plan, capacity)
+				if plan.Solver == SolverExact {
+					if plan.TotalValue != optimum {
+						t.Fatalf("trial %d: reported exact but value %d, optimum %d", trial, plan.TotalValue, optimum)
+					}
+					continue
+				}
+				if plan.Solver != SolverGreedy {
+					t.Fatalf("trial %d: solver %s, want %s", trial, plan.Solver, SolverGreedy)
+				}
+				if plan.ApproximationRatio <= 0 || plan.ApproximationRatio > 1 {
+					t.Fatalf("trial %d: ratio %v out of range", trial, plan.ApproximationRatio)
+				}
+				if float64(plan.TotalValue) < plan.ApproximationRatio*float64(optimum)-1e-9 {
+					t.Fatalf("trial %d: value %d < %v × optimum %d", trial, plan.TotalValue, plan.ApproximationRatio, optimum)
+				}
+				if plan.Exact != (plan.ApproximationRatio >= 1) {
+					t.Fatalf("trial %d: exact=%v with ratio %v", trial, plan.Exact, plan.ApproximationRatio)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+// 配送リースの設定を環境変数から取得
+func GetLeaseConfig() (leaseDuration, reapInterval time.Duration) {
+	// デフォルト値
+	leaseDuration = 10 * time.Minute
+	reapInterval = 30 * time.Second
+
+	if val := os.Getenv("DELIVERY_LEASE_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			leaseDuration = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if val := os.Getenv("DELIVERY_LEASE_REAP_INTERVAL_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			reapInterval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return leaseDuration, reapInterval
+}
+
+// ロボットが保持している注文のリースを延長し、延長件数と新しい期限を返す
+func (s *RobotService) ExtendLease(ctx context.Context, robotID string, orderIDs []int64) (int64, time.Time, error) {
+	leaseExpiresAt := time.Now().Add(s.leaseDuration)
+	var extended int64
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		extended, err = s.store.OrderRepo.ExtendLeases(ctx, robotID, orderIDs, leaseExpiresAt)
+		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return extended, leaseExpiresAt, nil
+}
+
+// 期限切れリースの注文をshippingに戻す処理をバックグラウンドで定期実行する
+func (s *RobotService) StartLeaseReaper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := utils.WithTimeout(context.Background(), s.releaseExpiredLeases)
+			if err != nil {
+				log.Printf("Failed to release expired delivery leases: %v", err)
+			}
+		}
+	}()
+}
+
+// リース期限切れのdelivering注文をshippingに戻し、遷移履歴を記録する
+func (s *RobotService) releaseExpiredLeases(ctx context.Context) error {
+	return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		orderIDs, err := txStore.OrderRepo.FindExpiredLeaseOrderIDs(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		if len(orderIDs) == 0 {
+			return nil
+		}
+
+		if err := txStore.OrderRepo.UpdateStatuses(ctx, orderIDs, OrderStatusShipping); err != nil {
+			return err
+		}
+		history := statusHistoryEntries(orderIDs, statusPtr(OrderStatusDelivering), OrderStatusShipping, systemLeaseReaperActor)
+		if err := txStore.OrderRepo.InsertStatusHistory(ctx, history); err != nil {
+			return err
+		}
+		log.Printf("Released %d orders with expired delivery lease back to 'shipping'", len(orderIDs))
+		return nil
+	})
+}
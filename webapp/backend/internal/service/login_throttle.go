@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ログイン失敗の制限設定
+type LoginThrottleConfig struct {
+	// ユーザー名ごと・IPごとに、この回数の失敗でロックを始める
+	MaxUserFailures int
+	MaxIPFailures   int
+	// 最初のロック時間。以降は失敗のたびに倍になり、MaxLockoutで頭打ちになる
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// 最後の失敗からこの時間が経てば失敗回数をリセットする
+	FailureWindow time.Duration
+}
+
+// ログイン試行回数の制限が掛かっている
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// ログイン失敗の制限設定を環境変数から取得
+func GetLoginThrottleConfig() LoginThrottleConfig {
+	// デフォルト値
+	cfg := LoginThrottleConfig{
+		MaxUserFailures: 5,
+		MaxIPFailures:   20,
+		BaseLockout:     30 * time.Second,
+		MaxLockout:      15 * time.Minute,
+		FailureWindow:   15 * time.Minute,
+	}
+
+	if val := os.Getenv("LOGIN_MAX_FAILURES_PER_USER"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.MaxUserFailures = n
+		}
+	}
+
+	if val := os.Getenv("LOGIN_MAX_FAILURES_PER_IP"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			cfg.MaxIPFailures = n
+		}
+	}
+
+	if val := os.Getenv("LOGIN_LOCKOUT_BASE_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			cfg.BaseLockout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if val := os.Getenv("LOGIN_LOCKOUT_MAX_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			cfg.MaxLockout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if val := os.Getenv("LOGIN_FAILURE_WINDOW_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			cfg.FailureWindow = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if cfg.MaxLockout < cfg.BaseLockout {
+		cfg.MaxLockout = cfg.BaseLockout
+	}
+	return cfg
+}
+
+type loginFailure struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// ユーザー名・IPごとのログイン失敗回数をプロセス内で数える
+type loginThrottle struct {
+	cfg     LoginThrottleConfig
+	mu      sync.Mutex
+	entries map[string]*loginFailure
+}
+
+func newLoginThrottle(cfg LoginThrottleConfig) *loginThrottle {
+	t := &loginThrottle{
+		cfg:     cfg,
+		entries: make(map[string]*loginFailure),
+	}
+
+	// バックグラウンドで古い失敗記録を削除
+	go t.cleanupStaleEntries()
+
+	return t
+}
+
+func loginUserKey(userName string) string {
+	return "user:" + strings.ToLower(userName)
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// ユーザー名かIPがロック中であれば、解除までの残り時間を返す
+func (t *loginThrottle) retryAfter(userName, ip string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range []string{loginUserKey(userName), loginIPKey(ip)} {
+		if f, ok := t.entries[key]; ok {
+			if d := f.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// 失敗を記録する
+func (t *loginThrottle) recordFailure(userName, ip string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.fail(loginUserKey(userName), t.cfg.MaxUserFailures, now)
+	if ip != "" {
+		t.fail(loginIPKey(ip), t.cfg.MaxIPFailures, now)
+	}
+}
+
+// 成功したユーザー名の失敗回数をリセットする
+// IPの失敗回数は、攻撃者が自分のアカウントでログインしてリセットできないよう残す
+func (t *loginThrottle) recordSuccess(userName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, loginUserKey(userName))
+}
+
+func (t *loginThrottle) fail(key string, threshold int, now time.Time) {
+	f, ok := t.entries[key]
+	if !ok || now.Sub(f.lastFailure) > t.cfg.FailureWindow {
+		f = &loginFailure{}
+		t.entries[key] = f
+	}
+	f.count++
+	f.lastFailure = now
+
+	if f.count < threshold {
+		return
+	}
+	// 閾値を超えた回数に応じて指数的にロック時間を延ばす
+	lockout := t.cfg.MaxLockout
+	if shift := f.count - threshold; shift < 32 {
+		if d := t.cfg.BaseLockout << uint(shift); d > 0 && d < lockout {
+			lockout = d
+		}
+	}
+	f.lockedUntil = now.Add(lockout)
+}
+
+// 失敗記録の定期削除
+func (t *loginThrottle) cleanupStaleEntries() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mu.Lock()
+		now := time.Now()
+		for key, f := range t.entries {
+			if now.After(f.lockedUntil) && now.Sub(f.lastFailure) > t.cfg.FailureWindow {
+				delete(t.entries, key)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
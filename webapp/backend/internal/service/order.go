@@ -5,8 +5,13 @@ import (
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 	"context"
+	"database/sql"
+	"errors"
+	"log"
 )
 
+var ErrCheckoutNotFound = errors.New("checkout not found")
+
 type OrderService struct {
 	store *repository.Store
 }
@@ -32,3 +37,87 @@ func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.Li
 	}
 	return orders, total, nil
 }
+
+// ユーザーの購入（ヘッダー・明細・荷物の注文ID）を取得
+// 他のユーザーの購入は存在しないものとして扱う
+func (s *OrderService) GetCheckout(ctx context.Context, userID int, checkoutID int64) (*model.Checkout, error) {
+	var checkout *model.Checkout
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		checkout, err = s.store.CheckoutRepo.FindByID(ctx, checkoutID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCheckoutNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if checkout.UserID != userID {
+			return ErrCheckoutNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkout, nil
+}
+
+// ユーザーの注文の詳細を取得
+// 他のユーザーの注文は存在しないものとして扱う
+// ステータス履歴の操作主体は種別(user/robot/system)のみを返す
+func (s *OrderService) GetOrder(ctx context.Context, userID int, orderID int64) (*model.OrderDetail, error) {
+	var order *model.OrderDetail
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		order, err = s.store.OrderRepo.FindDetailForUser(ctx, userID, orderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range order.History {
+		order.History[i].ActorKind = actorKind(order.History[i].Actor)
+	}
+	return order, nil
+}
+
+// ユーザーが自分の注文をキャンセルする
+// 配送待ち(shipping)の間のみ可能で、ロボットが同時に引き受けた場合は引き受けが優先される
+// 他のユーザーの注文は ErrOrderNotFound として扱う
+func (s *OrderService) CancelOrder(ctx context.Context, userID int, orderID int64) error {
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			cancelled, err := txStore.OrderRepo.CancelShipping(ctx, userID, orderID)
+			if err != nil {
+				return err
+			}
+			if !cancelled {
+				ownerID, _, err := txStore.OrderRepo.GetOwnerAndStatus(ctx, orderID)
+				if errors.Is(err, sql.ErrNoRows) || (err == nil && ownerID != userID) {
+					return ErrOrderNotFound
+				}
+				if err != nil {
+					return err
+				}
+				return ErrOrderNotCancellable
+			}
+
+			history := statusHistoryEntries([]int64{orderID}, statusPtr(OrderStatusShipping), OrderStatusCancelled, userActor(userID))
+			if err := txStore.OrderRepo.InsertStatusHistory(ctx, history); err != nil {
+				return err
+			}
+			return txStore.ProductRepo.ReleaseStockForOrders(ctx, []int64{orderID})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// コミット前に読み込まれた件数がキャッシュに残らないよう、コミット後にも無効化する
+	s.store.OrderRepo.InvalidateUserOrderCountCache(userID)
+	log.Printf("Order %d cancelled by user %d", orderID, userID)
+	return nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 注文ステータス
+// shipping → delivering → delivered が基本のライフサイクルで、
+// 配送前のキャンセル(cancelled)と返品(returned)を終端状態として持つ
+const (
+	OrderStatusShipping   = "shipping"
+	OrderStatusDelivering = "delivering"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusCancelled  = "cancelled"
+	OrderStatusReturned   = "returned"
+)
+
+var (
+	ErrOrderNotFound          = errors.New("order not found")
+	ErrDuplicateOrderUpdate   = errors.New("duplicate order_id in batch")
+	ErrInvalidOrderStatus     = errors.New("invalid order status")
+	ErrIllegalOrderTransition = errors.New("illegal order status transition")
+	ErrOrderNotCancellable    = errors.New("order can no longer be cancelled")
+	ErrOrderNotAssigned       = errors.New("order is not assigned to this robot")
+)
+
+// 各ステータスから遷移可能なステータス
+// delivering → shipping はリース切れや配送の取りやめで配送待ちに戻す場合に使う
+var orderStatusTransitions = map[string]map[string]bool{
+	OrderStatusShipping:   {OrderStatusDelivering: true, OrderStatusCancelled: true},
+	OrderStatusDelivering: {OrderStatusDelivered: true, OrderStatusShipping: true, OrderStatusReturned: true},
+	OrderStatusDelivered:  {OrderStatusReturned: true},
+	OrderStatusCancelled:  {},
+	OrderStatusReturned:   {},
+}
+
+// 旧クライアントが送ってくるステータス名の別名
+var orderStatusAliases = map[string]string{
+	"completed": OrderStatusDelivered,
+}
+
+// ステータス名を正規化し、未定義のステータスであればErrInvalidOrderStatusを返す
+func NormalizeOrderStatus(status string) (string, error) {
+	if alias, ok := orderStatusAliases[status]; ok {
+		status = alias
+	}
+	if _, ok := orderStatusTransitions[status]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidOrderStatus, status)
+	}
+	return status, nil
+}
+
+// from から to への遷移が許可されているかを判定
+func CanTransitionOrderStatus(from, to string) bool {
+	return orderStatusTransitions[from][to]
+}
+
+// ステータス遷移履歴に記録する操作主体
+func robotActor(robotID string) string { return "robot:" + robotID }
+func userActor(userID int) string      { return "user:" + strconv.Itoa(userID) }
+
+const systemLeaseReaperActor = "system:lease-reaper"
+
+// 操作主体の種別
+const (
+	ActorKindUser   = "user"
+	ActorKindRobot  = "robot"
+	ActorKindSystem = "system"
+)
+
+// 履歴の操作主体から、顧客に見せてよい種別のみを取り出す
+// ロボットIDや内部ジョブ名は返さず、未知の形式はsystemとして扱う
+func actorKind(actor string) string {
+	kind, _, _ := strings.Cut(actor, ":")
+	switch kind {
+	case ActorKindUser, ActorKindRobot:
+		return kind
+	default:
+		return ActorKindSystem
+	}
+}
+
+// 同じ遷移をした注文群の履歴エントリを生成
+func statusHistoryEntries(orderIDs []int64, from *string, to, actor string) []model.OrderStatusHistory {
+	entries := make([]model.OrderStatusHistory, len(orderIDs))
+	for i, id := range orderIDs {
+		entries[i] = model.OrderStatusHistory{
+			OrderID:    id,
+			FromStatus: from,
+			ToStatus:   to,
+			Actor:      actor,
+		}
+	}
+	return entries
+}
+
+func statusPtr(status string) *string { return &status }
+
+// トランザクション内で注文ステータスの更新を検証・適用し、遷移履歴を記録する
+// 戻り値のerrsはupdatesと同じ順で、各更新が拒否された理由(成功時はnil)を持つ
+// DBエラーなど全体を中断すべきエラーのみ第2戻り値で返す
+// ロボットからの更新ではそのロボットに割り当てられた注文のみ更新でき、それ以外はErrOrderNotAssignedとなる
+func applyOrderStatusUpdates(ctx context.Context, txStore *repository.Store, updates []model.UpdateOrderStatusRequest, robotID string) ([]error, error) {
+	actor := robotActor(robotID)
+	errs := make([]error, len(updates))
+	orderIDs := make([]int64, 0, len(updates))
+	seen := make(map[int64]bool, len(updates))
+	for _, u := range updates {
+		if !seen[u.OrderID] {
+			seen[u.OrderID] = true
+			orderIDs = append(orderIDs, u.OrderID)
+		}
+	}
+
+	statuses, err := txStore.OrderRepo.GetStatusesForUpdate(ctx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// 遷移先ステータスごとにまとめて一括UPDATEする
+	byStatus := make(map[string][]int64)
+	var history []model.OrderStatusHistory
+	applied := make(map[int64]bool, len(updates))
+	for i, u := range updates {
+		if applied[u.OrderID] {
+			errs[i] = ErrDuplicateOrderUpdate
+			continue
+		}
+		newStatus, err := NormalizeOrderStatus(u.NewStatus)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		locked, ok := statuses[u.OrderID]
+		if !ok {
+			errs[i] = ErrOrderNotFound
+			continue
+		}
+		if !locked.RobotID.Valid || locked.RobotID.String != robotID {
+			errs[i] = ErrOrderNotAssigned
+			continue
+		}
+		current := locked.ShippedStatus
+		if !CanTransitionOrderStatus(current, newStatus) {
+			errs[i] = fmt.Errorf("%w: %s -> %s", ErrIllegalOrderTransition, current, newStatus)
+			continue
+		}
+
+		applied[u.OrderID] = true
+		byStatus[newStatus] = append(byStatus[newStatus], u.OrderID)
+		history = append(history, model.OrderStatusHistory{
+			OrderID:    u.OrderID,
+			FromStatus: statusPtr(current),
+			ToStatus:   newStatus,
+			Actor:      actor,
+		})
+	}
+
+	for newStatus, ids := range byStatus {
+		if err := txStore.OrderRepo.UpdateStatuses(ctx, ids, newStatus); err != nil {
+			return nil, err
+		}
+	}
+	// 配送前にキャンセルされた注文の在庫を戻す
+	if err := txStore.ProductRepo.ReleaseStockForOrders(ctx, byStatus[OrderStatusCancelled]); err != nil {
+		return nil, err
+	}
+	if err := txStore.OrderRepo.InsertStatusHistory(ctx, history); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// パスワードポリシー
+// bcryptは72バイトを超える部分を無視するため、上限もバイト数で制限する
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// パスワードハッシュのbcryptコストを環境変数から取得
+func GetPasswordHashCost() int {
+	if val := os.Getenv("PASSWORD_BCRYPT_COST"); val != "" {
+		if cost, err := strconv.Atoi(val); err == nil && cost >= bcrypt.MinCost && cost <= bcrypt.MaxCost {
+			return cost
+		}
+	}
+	return bcrypt.DefaultCost
+}
+
+// パスワードをbcryptでハッシュ化
+func HashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// 保存されているハッシュとパスワードを照合する
+// 旧形式のMD5ハッシュも受け付け、その場合やbcryptのコストが設定と異なる場合はneedsRehashをtrueで返す
+func VerifyPassword(storedHash, password string, cost int) (ok bool, needsRehash bool, err error) {
+	if isLegacyMD5Hash(storedHash) {
+		match := subtle.ConstantTimeCompare([]byte(strings.ToLower(storedHash)), []byte(GetMD5Hash(password))) == 1
+		return match, match, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if hashCost, err := bcrypt.Cost([]byte(storedHash)); err == nil && hashCost != cost {
+		needsRehash = true
+	}
+	return true, needsRehash, nil
+}
+
+// 旧形式（MD5の16進文字列）のハッシュかどうか
+func isLegacyMD5Hash(hash string) bool {
+	if len(hash) != 32 {
+		return false
+	}
+	for _, c := range hash {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// パスワードがポリシーを満たしているか検証する
+// 満たしていない場合は理由を含めた ErrWeakPassword を返す
+func ValidatePassword(userName, password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, maxPasswordBytes)
+	}
+	if strings.EqualFold(password, userName) {
+		return fmt.Errorf("%w: must not be the same as the user name", ErrWeakPassword)
+	}
+	hasLetter, hasDigit := false, false
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return fmt.Errorf("%w: must contain both letters and digits", ErrWeakPassword)
+	}
+	return nil
+}
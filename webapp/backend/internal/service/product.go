@@ -2,61 +2,210 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	"backend/internal/model"
 	"backend/internal/repository"
 )
 
+// 在庫が不足している商品を含む注文
+type InsufficientStockError struct {
+	Items []model.StockShortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %d item(s)", len(e.Items))
+}
+
+// 存在しない商品を含む注文
+// 在庫不足とは異なりリクエストの誤りのため、在庫の確認より先に判定する
+type UnknownProductsError struct {
+	ProductIDs []int
+}
+
+func (e *UnknownProductsError) Error() string {
+	return fmt.Sprintf("unknown product(s): %v", e.ProductIDs)
+}
+
 type ProductService struct {
-	store *repository.Store
+	store             *repository.Store
+	idempotencyKeyTTL time.Duration
 }
 
-func NewProductService(store *repository.Store) *ProductService {
-	return &ProductService{store: store}
+func NewProductService(store *repository.Store, idempotencyKeyTTL time.Duration) *ProductService {
+	return &ProductService{store: store, idempotencyKeyTTL: idempotencyKeyTTL}
 }
 
-func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []model.RequestItem) ([]string, error) {
-	var insertedOrderIDs []string
+// 購入を作成する
+// idempotencyKey が指定された場合、同じキーでの再送には最初の結果をそのまま返し、
+// 異なる内容のリクエストでの再利用は ErrIdempotencyKeyReused とする
+func (s *ProductService) CreateOrders(ctx context.Context, userID int, idempotencyKey string, items []model.RequestItem) (*model.Checkout, []string, error) {
+	var requestHash string
+	if idempotencyKey != "" {
+		var err error
+		if requestHash, err = hashOrderRequest(items); err != nil {
+			return nil, nil, err
+		}
+	}
 
+	var checkout *model.Checkout
+	var insertedOrderIDs []string
 	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-		itemsToProcess := make(map[int]int)
-		for _, item := range items {
-			if item.Quantity > 0 {
-				itemsToProcess[item.ProductID] = item.Quantity
+		if idempotencyKey != "" {
+			expiresAt := time.Now().Add(s.idempotencyKeyTTL)
+			if err := txStore.IdempotencyRepo.Reserve(ctx, userID, idempotencyKey, requestHash, expiresAt); err != nil {
+				return err
 			}
 		}
-		if len(itemsToProcess) == 0 {
-			return nil
+
+		var err error
+		checkout, insertedOrderIDs, err = createOrders(ctx, txStore, userID, items)
+		if err != nil {
+			return err
 		}
 
-		// 全ての注文をスライスに格納
-		var orders []*model.Order
-		for pID, quantity := range itemsToProcess {
-			for i := 0; i < quantity; i++ {
-				order := &model.Order{
-					UserID:    userID,
-					ProductID: pID,
-				}
-				orders = append(orders, order)
+		if idempotencyKey != "" {
+			var checkoutID int64
+			var totalValue int
+			if checkout != nil {
+				checkoutID, totalValue = checkout.CheckoutID, checkout.TotalValue
 			}
+			return txStore.IdempotencyRepo.SaveResult(ctx, userID, idempotencyKey, checkoutID, totalValue, insertedOrderIDs)
 		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrDuplicateIdempotencyKey) {
+		return s.replayCreateOrders(ctx, userID, idempotencyKey, requestHash)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Created %d orders for user %d", len(insertedOrderIDs), userID)
+	return checkout, insertedOrderIDs, nil
+}
 
-		// バッチINSERTで一括作成
-		orderIDs, err := txStore.OrderRepo.CreateBatch(ctx, orders)
+// トランザクション内で在庫を引き当て、購入ヘッダーと商品ごとの明細（購入時の単価）を記録し、荷物として数量分の注文を作成する
+// 同じ商品が複数回指定された場合は最後の数量を採用する
+// 存在しない商品があれば *UnknownProductsError、在庫が足りない商品があれば *InsufficientStockError を返し、何も作成しない
+func createOrders(ctx context.Context, txStore *repository.Store, userID int, items []model.RequestItem) (*model.Checkout, []string, error) {
+	itemsToProcess := make(map[int]int)
+	var productIDs []int
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			continue
+		}
+		if _, ok := itemsToProcess[item.ProductID]; !ok {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		itemsToProcess[item.ProductID] = item.Quantity
+	}
+	if len(itemsToProcess) == 0 {
+		return nil, nil, nil
+	}
+
+	// 在庫と購入時点の単価を取得する（引き当てが終わるまで行をロック）
+	products, err := txStore.ProductRepo.FindByIDsForUpdate(ctx, productIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	productByID := make(map[int]model.Product, len(products))
+	for _, p := range products {
+		productByID[p.ProductID] = p
+	}
+
+	var unknown []int
+	for _, pID := range productIDs {
+		if _, ok := productByID[pID]; !ok {
+			unknown = append(unknown, pID)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, nil, &UnknownProductsError{ProductIDs: unknown}
+	}
+
+	var shortages []model.StockShortage
+	for _, pID := range productIDs {
+		quantity := itemsToProcess[pID]
+		if p := productByID[pID]; p.Stock < quantity {
+			shortages = append(shortages, model.StockShortage{
+				ProductID: pID,
+				Requested: quantity,
+				Available: p.Stock,
+			})
+		}
+	}
+	if len(shortages) > 0 {
+		return nil, nil, &InsufficientStockError{Items: shortages}
+	}
+
+	checkout := &model.Checkout{UserID: userID}
+	for _, pID := range productIDs {
+		quantity := itemsToProcess[pID]
+		reserved, err := txStore.ProductRepo.ReserveStock(ctx, pID, quantity)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		insertedOrderIDs = orderIDs
-		
-		return nil
-	})
+		if !reserved {
+			return nil, nil, &InsufficientStockError{Items: []model.StockShortage{{ProductID: pID, Requested: quantity}}}
+		}
+		price := productByID[pID].Value
+		checkout.Lines = append(checkout.Lines, model.CheckoutLine{
+			ProductID: pID,
+			Quantity:  quantity,
+			UnitPrice: price,
+		})
+		checkout.ItemCount += quantity
+		checkout.TotalValue += price * quantity
+	}
+	if err := txStore.CheckoutRepo.Create(ctx, checkout); err != nil {
+		return nil, nil, err
+	}
 
+	// 配送ロボットの計画単位として、荷物1個につき1件の注文をスライスに格納
+	var orders []*model.Order
+	for _, line := range checkout.Lines {
+		for i := 0; i < line.Quantity; i++ {
+			order := &model.Order{
+				UserID:     userID,
+				CheckoutID: checkout.CheckoutID,
+				LineID:     line.LineID,
+				ProductID:  line.ProductID,
+			}
+			orders = append(orders, order)
+		}
+	}
+
+	// バッチINSERTで一括作成
+	orderIDs, err := txStore.OrderRepo.CreateBatch(ctx, orders)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	log.Printf("Created %d orders for user %d", len(insertedOrderIDs), userID)
-	return insertedOrderIDs, nil
+
+	// 作成時点を遷移履歴の起点として記録
+	createdIDs := make([]int64, len(orderIDs))
+	for i, id := range orderIDs {
+		createdIDs[i], err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	// 注文IDは明細の順に採番されている
+	offset := 0
+	for i := range checkout.Lines {
+		line := &checkout.Lines[i]
+		line.OrderIDs = createdIDs[offset : offset+line.Quantity]
+		offset += line.Quantity
+	}
+	history := statusHistoryEntries(createdIDs, nil, OrderStatusShipping, userActor(userID))
+	if err := txStore.OrderRepo.InsertStatusHistory(ctx, history); err != nil {
+		return nil, nil, err
+	}
+
+	return checkout, orderIDs, nil
 }
 
 func (s *ProductService) FetchProducts(ctx context.Context, userID int, req model.ListRequest) ([]model.Product, int, error) {
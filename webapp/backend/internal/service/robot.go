@@ -5,64 +5,297 @@ import (
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 	"context"
-	"math/bits"
+	"database/sql"
+	"errors"
 	"log"
+	"math/bits"
+	"time"
+)
+
+// ロボットの稼働状態
+const (
+	RobotStatusIdle       = "idle"
+	RobotStatusDelivering = "delivering"
+)
+
+var (
+	ErrRobotNotFound        = errors.New("robot not found")
+	ErrDeliveryPlanNotFound = errors.New("delivery plan not found")
+	// 同時に計画した他のロボットと注文の取り合いが解消しなかった
+	ErrPlanConflict = errors.New("delivery plan conflicted with concurrent planners")
 )
 
+// 割り当て時に他のロボットと注文が競合した場合（デッドロックを含む）に計画をやり直す回数
+const maxPlanAttempts = 5
+
+// 計画のやり直しが必要なことを表す内部エラー
+var errOrdersClaimedConcurrently = errors.New("orders were claimed concurrently")
+
 type RobotService struct {
-	store *repository.Store
+	store              *repository.Store
+	leaseDuration      time.Duration
+	solverBudget       int64
+	keyRotationOverlap time.Duration
+	secretCipher       *repository.RobotSecretCipher
+}
+
+func NewRobotService(store *repository.Store, leaseDuration time.Duration, solverBudget int64, keyRotationOverlap time.Duration, secretCipher *repository.RobotSecretCipher) *RobotService {
+	return &RobotService{
+		store:              store,
+		leaseDuration:      leaseDuration,
+		solverBudget:       solverBudget,
+		keyRotationOverlap: keyRotationOverlap,
+		secretCipher:       secretCipher,
+	}
+}
+
+// 配送計画を作成し、選ばれた注文をロボットに割り当てる
+func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, capacity model.DeliveryCapacity, opts model.DeliveryPlanOptions) (*model.DeliveryPlan, error) {
+	return s.generateDeliveryPlan(ctx, robotID, capacity, opts, true)
 }
 
-func NewRobotService(store *repository.Store) *RobotService {
-	return &RobotService{store: store}
+// 注文を割り当てずに配送計画だけを作成する（ダッシュボードやデバッグ用のドライラン）
+func (s *RobotService) PreviewDeliveryPlan(ctx context.Context, robotID string, capacity model.DeliveryCapacity, opts model.DeliveryPlanOptions) (*model.DeliveryPlan, error) {
+	return s.generateDeliveryPlan(ctx, robotID, capacity, opts, false)
 }
 
-func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, capacity int) (*model.DeliveryPlan, error) {
+// commitがfalseの場合は同じスナップショットで選択だけを行い、ステータスは変更しない
+// 割り当てはshippingのままの注文だけを条件付きUPDATEで確保し、同時に計画した他のロボットに
+// 1件でも先取りされていればトランザクションをロールバックして最新の状態から計画し直す
+// これにより同時に発行された計画同士で注文が重複することはない
+func (s *RobotService) generateDeliveryPlan(ctx context.Context, robotID string, capacity model.DeliveryCapacity, opts model.DeliveryPlanOptions, commit bool) (*model.DeliveryPlan, error) {
 	var plan model.DeliveryPlan
 
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		for attempt := 1; ; attempt++ {
+			err := s.planOnce(ctx, robotID, capacity, opts, commit, &plan)
+			// 他の計画と注文が競合した場合と、同時に割り当てた計画とのデッドロックはやり直す
+			if !errors.Is(err, errOrdersClaimedConcurrently) && !repository.IsDeadlock(err) {
+				return err
+			}
+			if attempt >= maxPlanAttempts {
+				return ErrPlanConflict
+			}
+			log.Printf("Delivery plan for robot %s conflicted with a concurrent planner, retrying (attempt %d): %v", robotID, attempt, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// 1回分の計画と割り当てを1トランザクションで行う
+func (s *RobotService) planOnce(ctx context.Context, robotID string, capacity model.DeliveryCapacity, opts model.DeliveryPlanOptions, commit bool, out *model.DeliveryPlan) error {
+	return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		orders, err := txStore.OrderRepo.GetShippingOrders(ctx)
+		if err != nil {
+			return err
+		}
+		started := time.Now()
+		plan, err := planDelivery(ctx, orders, robotID, capacity, opts, s.solverBudget, started)
+		if err != nil {
+			return err
+		}
+		plan.CandidateCount = len(orders)
+		plan.SolveTimeMillis = time.Since(started).Milliseconds()
+		if !commit {
+			plan.Preview = true
+			*out = plan
+			return nil
+		}
+		if len(plan.Orders) > 0 {
+			orderIDs := make([]int64, len(plan.Orders))
+			for i, order := range plan.Orders {
+				orderIDs[i] = order.OrderID
+			}
+
+			leaseExpiresAt := time.Now().Add(s.leaseDuration)
+			claimed, err := txStore.OrderRepo.AssignToRobot(ctx, orderIDs, robotID, leaseExpiresAt)
+			if err != nil {
+				return err
+			}
+			if claimed != int64(len(orderIDs)) {
+				return errOrdersClaimedConcurrently
+			}
+			plan.LeaseExpiresAt = &leaseExpiresAt
+			history := statusHistoryEntries(orderIDs, statusPtr(OrderStatusShipping), OrderStatusDelivering, robotActor(robotID))
+			if err := txStore.OrderRepo.InsertStatusHistory(ctx, history); err != nil {
+				return err
+			}
+			if err := txStore.RobotRepo.UpdateStatus(ctx, robotID, RobotStatusDelivering); err != nil {
+				return err
+			}
+			log.Printf("Assigned %d orders to robot %s (status 'delivering')", len(orderIDs), robotID)
+		}
+
+		// 発行した計画を監査用に記録（割り当てる注文がない計画は記録しない）
+		if len(plan.Orders) == 0 {
+			*out = plan
+			return nil
+		}
+		planID, err := txStore.PlanRepo.Create(ctx, deliveryPlanRecord(plan, capacity))
+		if err != nil {
+			return err
+		}
+		plan.PlanID = planID
+		*out = plan
+		return nil
+	})
+}
+
+// 配送計画から履歴に記録する内容を組み立てる
+func deliveryPlanRecord(plan model.DeliveryPlan, capacity model.DeliveryCapacity) *model.DeliveryPlanRecord {
+	rec := &model.DeliveryPlanRecord{
+		RobotID:            plan.RobotID,
+		Capacity:           capacity.Weight,
+		VolumeCapacity:     capacity.Volume,
+		MaxItems:           capacity.Items,
+		Objective:          plan.Objective,
+		Solver:             plan.Solver,
+		Exact:              plan.Exact,
+		ApproximationRatio: plan.ApproximationRatio,
+		TotalWeight:        plan.TotalWeight,
+		TotalVolume:        plan.TotalVolume,
+		TotalValue:         plan.TotalValue,
+		OrderCount:         len(plan.Orders),
+		CandidateCount:     plan.CandidateCount,
+		SolveTimeMillis:    plan.SolveTimeMillis,
+		OrderIDs:           make([]int64, len(plan.Orders)),
+	}
+	for i, o := range plan.Orders {
+		rec.OrderIDs[i] = o.OrderID
+	}
+	if plan.LeaseExpiresAt != nil {
+		rec.LeaseExpiresAt = sql.NullTime{Time: *plan.LeaseExpiresAt, Valid: true}
+	}
+	return rec
+}
+
+// 注文ステータスを更新
+// 未定義のステータスはErrInvalidOrderStatus、許可されていない遷移はErrIllegalOrderTransition、
+// 他のロボットに割り当てられた注文はErrOrderNotAssignedを返す
+func (s *RobotService) UpdateOrderStatus(ctx context.Context, robotID string, orderID int64, newStatus string) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
 		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-			orders, err := txStore.OrderRepo.GetShippingOrders(ctx)
+			updates := []model.UpdateOrderStatusRequest{{OrderID: orderID, NewStatus: newStatus}}
+			errs, err := applyOrderStatusUpdates(ctx, txStore, updates, robotID)
 			if err != nil {
 				return err
 			}
-			plan, err = selectOrdersForDelivery(ctx, orders, robotID, capacity)
+			if errs[0] != nil {
+				return errs[0]
+			}
+			return txStore.RobotRepo.MarkIdleIfNoDeliveries(ctx, robotID)
+		})
+	})
+}
+
+// 複数の注文ステータスを1トランザクションで更新し、注文ごとの結果を返す
+// 不明な注文IDや許可されていない遷移は該当する注文の結果にのみ反映され、他の注文の更新は行われる
+func (s *RobotService) UpdateOrderStatuses(ctx context.Context, robotID string, updates []model.UpdateOrderStatusRequest) ([]model.OrderStatusUpdateResult, error) {
+	var results []model.OrderStatusUpdateResult
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			errs, err := applyOrderStatusUpdates(ctx, txStore, updates, robotID)
 			if err != nil {
 				return err
 			}
-			if len(plan.Orders) > 0 {
-				orderIDs := make([]int64, len(plan.Orders))
-				for i, order := range plan.Orders {
-					orderIDs[i] = order.OrderID
+			results = make([]model.OrderStatusUpdateResult, len(updates))
+			for i, u := range updates {
+				results[i] = model.OrderStatusUpdateResult{
+					OrderID:   u.OrderID,
+					NewStatus: u.NewStatus,
+					Updated:   errs[i] == nil,
 				}
-
-				if err := txStore.OrderRepo.UpdateStatuses(ctx, orderIDs, "delivering"); err != nil {
-					return err
+				if errs[i] != nil {
+					results[i].Error = errs[i].Error()
 				}
-				log.Printf("Updated status to 'delivering' for %d orders", len(orderIDs))
 			}
-			return nil
+			return txStore.RobotRepo.MarkIdleIfNoDeliveries(ctx, robotID)
 		})
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &plan, nil
+	return results, nil
 }
 
-func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string) error {
-	return utils.WithTimeout(ctx, func(ctx context.Context) error {
-		return s.store.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, newStatus)
+// ロボットIDからロボット情報を取得
+func (s *RobotService) GetRobot(ctx context.Context, robotID string) (*model.Robot, error) {
+	var robot *model.Robot
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		robot, err = s.store.RobotRepo.FindByID(ctx, robotID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRobotNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return robot, nil
+}
+
+// 配送計画の履歴を新しい順に取得
+func (s *RobotService) ListDeliveryPlans(ctx context.Context, robotID string, req model.ListRequest) ([]model.DeliveryPlanRecord, int, error) {
+	var plans []model.DeliveryPlanRecord
+	var total int
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		plans, total, err = s.store.PlanRepo.List(ctx, robotID, req.PageSize, req.Offset)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return plans, total, nil
+}
+
+// 配送計画の履歴を計画IDから取得
+func (s *RobotService) GetDeliveryPlanRecord(ctx context.Context, planID int64) (*model.DeliveryPlanRecord, error) {
+	var plan *model.DeliveryPlanRecord
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		plan, err = s.store.PlanRepo.FindByID(ctx, planID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDeliveryPlanNotFound
+		}
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
 }
 
-func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID string, capacity int) (model.DeliveryPlan, error) {
+// 登録済みロボットの一覧を取得
+func (s *RobotService) ListRobots(ctx context.Context) ([]model.Robot, error) {
+	var robots []model.Robot
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		robots, err = s.store.RobotRepo.List(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return robots, nil
+}
+
+// 積載量内で価値の合計が最大になる注文の組み合わせを選ぶ
+// DPの表サイズ n×W(GCD圧縮後) が budget を超える場合は近似解法に切り替える
+func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID string, capacity int, budget int64) (model.DeliveryPlan, error) {
 	n, W := len(orders), capacity
 	if n == 0 || W <= 0 {
 		return model.DeliveryPlan{
-            RobotID: robotID,
-            Orders:  []model.Order{}, // ★ nil ではなく空スライス
-        }, nil
+			RobotID:            robotID,
+			Orders:             []model.Order{}, // ★ nil ではなく空スライス
+			Solver:             SolverExact,
+			Exact:              true,
+			ApproximationRatio: 1,
+		}, nil
 	}
 
 	// 早期：全部載る
@@ -75,10 +308,13 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 		best := make([]model.Order, n)
 		copy(best, orders)
 		return model.DeliveryPlan{
-			RobotID:     robotID,
-			TotalWeight: sumW,
-			TotalValue:  sumV,
-			Orders:      best,
+			RobotID:            robotID,
+			TotalWeight:        sumW,
+			TotalValue:         sumV,
+			Orders:             best,
+			Solver:             SolverExact,
+			Exact:              true,
+			ApproximationRatio: 1,
 		}, nil
 	}
 
@@ -104,6 +340,11 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 		W = 0
 	}
 
+	// 表が大きすぎる場合はメモリとタイムアウトを避けるため近似解法を使う
+	if int64(n)*int64(W+1) > budget {
+		return selectOrdersApprox(ctx, orders, robotID, capacity, budget)
+	}
+
 	// === 2行DP（prev/cur） + chooseビットセット（復元用） ===
 	dpPrev := make([]int, W+1)
 	dpCur := make([]int, W+1)
@@ -196,12 +437,11 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 	}
 
 	// 最終価値と、最大価値を達成する「最大の重さ」bestW（持ち上げ対策）
-	bestValue := dpPrev[W]
-	bestW := W
+	// dpPrev[w] は重さがちょうど w の場合の最大価値なので、W 以下の全体から最大を探す
+	bestValue, bestW := 0, 0
 	for w := W; w >= 0; w-- {
-		if dpPrev[w] == bestValue {
-			bestW = w
-			break
+		if dpPrev[w] > bestValue {
+			bestValue, bestW = dpPrev[w], w
 		}
 	}
 
@@ -234,10 +474,13 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 	}
 
 	return model.DeliveryPlan{
-		RobotID:     robotID,
-		TotalWeight: totalWeight,  // 例: 50
-		TotalValue:  bestValue,    // 例: 236
-		Orders:      bestSet,      // 期待のorder集合
+		RobotID:            robotID,
+		TotalWeight:        totalWeight, // 例: 50
+		TotalValue:         bestValue,   // 例: 236
+		Orders:             bestSet,     // 期待のorder集合
+		Solver:             SolverExact,
+		Exact:              true,
+		ApproximationRatio: 1,
 	}, nil
 }
 
@@ -252,4 +495,4 @@ func gcd(a, b int) int {
 		return -a
 	}
 	return a
-}
\ No newline at end of file
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+// 発行するAPIキーの接頭辞とランダム部分のバイト数
+const (
+	robotAPIKeyPrefix = "rk_"
+	robotAPIKeyBytes  = 32
+)
+
+// APIキーのローテーション時に旧キーを使える猶予期間を環境変数から取得
+func GetRobotKeyRotationOverlap() time.Duration {
+	// デフォルト値
+	overlap := 24 * time.Hour
+
+	if val := os.Getenv("ROBOT_KEY_ROTATION_OVERLAP_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds >= 0 {
+			overlap = time.Duration(seconds) * time.Second
+		}
+	}
+	return overlap
+}
+
+// ランダムなAPIキーを生成
+func generateRobotAPIKey() (string, error) {
+	buf := make([]byte, robotAPIKeyBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return robotAPIKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+// ロボットに新しいAPIキーを発行し、既存のキーには猶予期限を設定する
+// 平文のキーは戻り値でのみ返し、保存するのは照合用のハッシュと暗号化した署名用の鍵のみ
+func (s *RobotService) RotateAPIKey(ctx context.Context, robotID string) (*model.RotatedRobotAPIKey, error) {
+	apiKey, err := generateRobotAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	signingSecret, err := repository.DeriveRobotSigningSecret(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	sealedSecret, err := s.secretCipher.Seal(signingSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := &model.RotatedRobotAPIKey{RobotID: robotID, APIKey: apiKey}
+	err = utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if _, err := txStore.RobotRepo.FindByID(ctx, robotID); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrRobotNotFound
+				}
+				return err
+			}
+
+			keyID, err := txStore.RobotRepo.CreateAPIKey(ctx, robotID, apiKey, sealedSecret)
+			if err != nil {
+				return err
+			}
+			rotated.KeyID = keyID
+
+			expiresAt := time.Now().Add(s.keyRotationOverlap)
+			expired, err := txStore.RobotRepo.ExpireOtherAPIKeys(ctx, robotID, keyID, expiresAt)
+			if err != nil {
+				return err
+			}
+			if expired > 0 {
+				rotated.PreviousKeysExpireAt = &expiresAt
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Rotated API key for robot %s (key_id: %d)", robotID, rotated.KeyID)
+	return rotated, nil
+}
@@ -0,0 +1,117 @@
+//go:build integration
+
+// 複数のロボットが同時に配送計画を作成しても、同じ注文が二重に割り当てられないことを確認する
+// DATABASE_URL の指すDBの shipping 注文を実際に delivering へ更新するため、検証用のDBに対してのみ実行すること
+//
+//	DATABASE_URL='user:password@tcp(127.0.0.1:3306)/42Tokyo2508-db' go test -tags integration -run TestConcurrentPlannersNeverShareOrders ./internal/service/
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/db"
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+const (
+	stressPlanners     = 32
+	stressRounds       = 20
+	stressCapacity     = 100
+	stressSeededOrders = 500
+	stressRobotPrefix  = "stress-"
+)
+
+func TestConcurrentPlannersNeverShareOrders(t *testing.T) {
+	dbConn, err := db.InitDBConnection()
+	if err != nil {
+		t.Skipf("database is not available: %v", err)
+	}
+	defer dbConn.Close()
+
+	store := repository.NewStore(dbConn)
+	ctx := context.Background()
+
+	// 既存のユーザー・商品で shipping の注文を用意する
+	var userID, productID int
+	if err := dbConn.GetContext(ctx, &userID, "SELECT user_id FROM users ORDER BY user_id LIMIT 1"); err != nil {
+		t.Fatalf("failed to pick a user: %v", err)
+	}
+	if err := dbConn.GetContext(ctx, &productID, "SELECT product_id FROM products WHERE weight > 0 ORDER BY product_id LIMIT 1"); err != nil {
+		t.Fatalf("failed to pick a product: %v", err)
+	}
+	seed := make([]*model.Order, stressSeededOrders)
+	for i := range seed {
+		seed[i] = &model.Order{UserID: userID, ProductID: productID}
+	}
+	seededIDs, err := store.OrderRepo.CreateBatch(ctx, seed)
+	if err != nil {
+		t.Fatalf("failed to seed orders: %v", err)
+	}
+	t.Cleanup(func() {
+		dbConn.ExecContext(ctx, "DELETE FROM delivery_plans WHERE robot_id LIKE ?", stressRobotPrefix+"%")
+		for _, id := range seededIDs {
+			dbConn.ExecContext(ctx, "DELETE FROM orders WHERE order_id = ?", id)
+		}
+	})
+
+	// リースが切れて注文が再割り当てされないよう、十分長いリースで実行する
+	robotService := NewRobotService(store, 24*time.Hour, GetSolverBudget(), GetRobotKeyRotationOverlap(), nil)
+
+	var (
+		mu         sync.Mutex
+		assigned   = make(map[int64]string)
+		duplicates []string
+		failures   []string
+		plans      int
+		conflicts  int
+	)
+	var wg sync.WaitGroup
+	for p := 0; p < stressPlanners; p++ {
+		wg.Add(1)
+		go func(robotID string) {
+			defer wg.Done()
+			for i := 0; i < stressRounds; i++ {
+				plan, err := robotService.GenerateDeliveryPlan(ctx, robotID,
+					model.DeliveryCapacity{Weight: stressCapacity, Volume: -1, Items: -1},
+					model.DeliveryPlanOptions{Objective: DeliveryObjectiveValue})
+
+				mu.Lock()
+				switch {
+				case errors.Is(err, ErrPlanConflict):
+					conflicts++
+				case err != nil:
+					failures = append(failures, fmt.Sprintf("%s: %v", robotID, err))
+				default:
+					plans++
+					for _, o := range plan.Orders {
+						if owner, ok := assigned[o.OrderID]; ok {
+							duplicates = append(duplicates, fmt.Sprintf("order %d: %s and %s", o.OrderID, owner, robotID))
+						}
+						assigned[o.OrderID] = robotID
+					}
+				}
+				mu.Unlock()
+			}
+		}(stressRobotPrefix + strconv.Itoa(p))
+	}
+	wg.Wait()
+
+	t.Logf("planners=%d rounds=%d plans=%d assigned_orders=%d conflicts=%d",
+		stressPlanners, stressRounds, plans, len(assigned), conflicts)
+	for _, d := range duplicates {
+		t.Errorf("duplicate assignment: %s", d)
+	}
+	for _, f := range failures {
+		t.Errorf("plan failed: %s", f)
+	}
+	if plans == 0 {
+		t.Error("no delivery plan was created")
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"backend/internal/service/utils"
+)
+
+// セッションの設定を環境変数から取得
+func GetSessionConfig() (sessionDuration, cleanupInterval time.Duration) {
+	// デフォルト値
+	sessionDuration = 24 * time.Hour
+	cleanupInterval = 10 * time.Minute
+
+	if val := os.Getenv("SESSION_DURATION_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			sessionDuration = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if val := os.Getenv("SESSION_CLEANUP_INTERVAL_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			cleanupInterval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return sessionDuration, cleanupInterval
+}
+
+// 期限切れセッションとリフレッシュトークンの削除をバックグラウンドで定期実行する
+func (s *AuthService) StartSessionCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := utils.WithTimeout(context.Background(), func(ctx context.Context) error {
+				deleted, err := s.store.SessionRepo.DeleteExpired(ctx, time.Now())
+				if err != nil {
+					return err
+				}
+				if deleted > 0 {
+					log.Printf("Deleted %d expired sessions", deleted)
+				}
+				deleted, err = s.store.RefreshTokenRepo.DeleteExpired(ctx, time.Now())
+				if err != nil {
+					return err
+				}
+				if deleted > 0 {
+					log.Printf("Deleted %d expired or revoked refresh tokens", deleted)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("Failed to delete expired sessions and refresh tokens: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+
+	"go.opentelemetry.io/otel"
+)
+
+var (
+	ErrInvalidAccessToken  = errors.New("invalid or expired access token")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+)
+
+// アクセストークンのヘッダー部（HS256のJWT）
+// 検証時はこの値と完全一致することを確認し、alg の差し替えを受け付けない
+var accessTokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// トークン認証の設定
+type TokenConfig struct {
+	// アクセストークンの署名鍵（空の場合はトークン認証を使えない）
+	Secret     []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// トークン認証の設定を環境変数から取得
+func GetTokenConfig() TokenConfig {
+	// デフォルト値
+	cfg := TokenConfig{
+		Secret:     []byte(os.Getenv("ACCESS_TOKEN_SECRET")),
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+	}
+
+	if val := os.Getenv("ACCESS_TOKEN_TTL_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			cfg.AccessTTL = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if val := os.Getenv("REFRESH_TOKEN_TTL_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			cfg.RefreshTTL = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return cfg
+}
+
+// ランダムな署名鍵を生成（ローカル環境で ACCESS_TOKEN_SECRET が未設定の場合に使う）
+func GenerateTokenSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+type accessTokenClaims struct {
+	Subject  string `json:"sub"`
+	Role     string `json:"role"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+// ユーザー名とパスワードを照合し、アクセストークンとリフレッシュトークンを発行する
+// 照合と試行回数の制限はCookieのログインと共通
+func (s *AuthService) IssueTokens(ctx context.Context, userName, password, clientIP string) (*model.TokenResponse, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.IssueTokens")
+	defer span.End()
+
+	user, err := s.authenticate(ctx, userName, password, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens *model.TokenResponse
+	err = utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		tokens, err = s.issueTokenPair(ctx, s.store, model.SessionUser{UserID: user.UserID, Role: user.Role})
+		return err
+	})
+	if err != nil {
+		log.Printf("[IssueTokens] トークン発行失敗(userName: %s): %v", userName, err)
+		return nil, ErrInternalServer
+	}
+	log.Printf("Token login successful for UserName '%s'", userName)
+	return tokens, nil
+}
+
+// リフレッシュトークンを使用済みにし、新しいトークンの組を発行する
+// ロールはユーザー情報から読み直すため、変更はリフレッシュ時に反映される
+func (s *AuthService) RefreshTokens(ctx context.Context, refreshToken string) (*model.TokenResponse, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.RefreshTokens")
+	defer span.End()
+
+	var tokens *model.TokenResponse
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			userID, err := txStore.RefreshTokenRepo.Consume(ctx, refreshToken)
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidRefreshToken
+			}
+			if err != nil {
+				return err
+			}
+			user, err := txStore.UserRepo.FindByID(ctx, userID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidRefreshToken
+			}
+			if err != nil {
+				return err
+			}
+			tokens, err = s.issueTokenPair(ctx, txStore, model.SessionUser{UserID: user.UserID, Role: user.Role})
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// アクセストークンを検証し、ユーザーIDとロールを返す
+func (s *AuthService) VerifyAccessToken(token string) (*model.SessionUser, error) {
+	if len(s.tokens.Secret) == 0 {
+		return nil, ErrInvalidAccessToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != accessTokenHeader {
+		return nil, ErrInvalidAccessToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, s.signAccessToken(parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	var claims accessTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, ErrInvalidAccessToken
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	return &model.SessionUser{UserID: userID, Role: claims.Role}, nil
+}
+
+// アクセストークンを署名し、リフレッシュトークンを登録する
+func (s *AuthService) issueTokenPair(ctx context.Context, store *repository.Store, user model.SessionUser) (*model.TokenResponse, error) {
+	if len(s.tokens.Secret) == 0 {
+		return nil, errors.New("access token secret is not configured")
+	}
+	now := time.Now()
+
+	payload, err := json.Marshal(accessTokenClaims{
+		Subject:  strconv.Itoa(user.UserID),
+		Role:     user.Role,
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(s.tokens.AccessTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signingInput := accessTokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	accessToken := signingInput + "." + base64.RawURLEncoding.EncodeToString(s.signAccessToken(signingInput))
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	refreshToken := hex.EncodeToString(buf)
+	refreshExpiresAt := now.Add(s.tokens.RefreshTTL)
+	if err := store.RefreshTokenRepo.Create(ctx, user.UserID, refreshToken, refreshExpiresAt); err != nil {
+		return nil, err
+	}
+
+	return &model.TokenResponse{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.tokens.AccessTTL.Seconds()),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+func (s *AuthService) signAccessToken(signingInput string) []byte {
+	mac := hmac.New(sha256.New, s.tokens.Secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -23,7 +23,14 @@ import {
 } from "@mui/material";
 import { useRouter } from "next/navigation";
 
-type ShippedStatus = "completed" | "delivering" | "shipping";
+type ShippedStatus =
+  | "shipping"
+  | "delivering"
+  | "delivered"
+  | "cancelled"
+  | "returned"
+  // 旧ステータス名（サーバーでは delivered に統一済み）
+  | "completed";
 
 type OrdersRow = {
   id: number;
@@ -186,12 +193,17 @@ export default function OrdersPage() {
 
   function renderStatus(status: ShippedStatus) {
     switch (status) {
+      case "delivered":
       case "completed":
         return <Chip label="配送完了" color="success" size="small" />;
       case "delivering":
         return <Chip label="配送中" color="primary" size="small" />;
       case "shipping":
         return <Chip label="出荷準備" color="default" size="small" />;
+      case "cancelled":
+        return <Chip label="キャンセル" color="warning" size="small" />;
+      case "returned":
+        return <Chip label="返品" color="error" size="small" />;
       default:
         return <Chip label="不明" color="default" size="small" />;
     }
@@ -109,6 +109,10 @@ export default function ProductListPage() {
       setQuantities({});
     } catch (error) {
       console.error("エラー:", error);
+      if (axios.isAxiosError(error) && error.response?.status === 409) {
+        alert("在庫が不足している商品があります。数量を変更してください。");
+        return;
+      }
       alert("注文の送信に失敗しました。");
     }
   };
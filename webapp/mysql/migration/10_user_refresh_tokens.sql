@@ -0,0 +1,17 @@
+-- ========================================
+-- API クライアント向けのリフレッシュトークン
+-- ========================================
+
+-- token_hash はトークンの SHA-256（16進）。使用済み・失効したトークンは revoked_at を設定する
+CREATE TABLE user_refresh_tokens (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_user_refresh_tokens_hash (token_hash),
+    INDEX idx_user_refresh_tokens_user (user_id),
+    INDEX idx_user_refresh_tokens_expires (expires_at),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,50 @@
+-- ========================================
+-- 注文のヘッダー（1回の購入）と明細（商品ごとの数量・購入時単価）
+-- orders は引き続き荷物1個につき1行とし、配送ロボットの計画・ステータス管理の単位にする
+-- ========================================
+
+CREATE TABLE checkouts (
+    checkout_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    item_count INT UNSIGNED NOT NULL,
+    total_value BIGINT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_checkouts_user_created (user_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE checkout_lines (
+    line_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    checkout_id BIGINT NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    quantity INT UNSIGNED NOT NULL,
+    unit_price INT UNSIGNED NOT NULL,
+    UNIQUE KEY uk_checkout_lines_product (checkout_id, product_id),
+    FOREIGN KEY (checkout_id) REFERENCES checkouts(checkout_id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+-- 荷物がどの購入・明細に属するか
+ALTER TABLE orders ADD COLUMN checkout_id BIGINT NULL;
+ALTER TABLE orders ADD COLUMN line_id BIGINT NULL;
+CREATE INDEX idx_orders_checkout ON orders (checkout_id);
+
+-- 既存の注文は同じユーザー・同じ作成日時の行を1回の購入としてまとめる
+-- 購入時の単価は残っていないため、移行時点の商品価格を使う
+INSERT INTO checkouts (user_id, item_count, total_value, created_at)
+SELECT o.user_id, COUNT(*), SUM(p.value), o.created_at
+FROM orders o
+JOIN products p ON p.product_id = o.product_id
+GROUP BY o.user_id, o.created_at;
+
+INSERT INTO checkout_lines (checkout_id, product_id, quantity, unit_price)
+SELECT c.checkout_id, o.product_id, COUNT(*), MAX(p.value)
+FROM orders o
+JOIN checkouts c ON c.user_id = o.user_id AND c.created_at = o.created_at
+JOIN products p ON p.product_id = o.product_id
+GROUP BY c.checkout_id, o.product_id;
+
+UPDATE orders o
+JOIN checkouts c ON c.user_id = o.user_id AND c.created_at = o.created_at
+JOIN checkout_lines l ON l.checkout_id = c.checkout_id AND l.product_id = o.product_id
+SET o.checkout_id = c.checkout_id, o.line_id = l.line_id;
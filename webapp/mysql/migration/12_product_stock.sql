@@ -0,0 +1,10 @@
+-- ========================================
+-- 商品の在庫数
+-- ========================================
+
+-- 注文作成時に数量分を引き当て、配送前のキャンセルで戻す
+ALTER TABLE products ADD COLUMN stock INT UNSIGNED NOT NULL DEFAULT 0;
+
+-- 既存の商品は引き続き注文できるよう十分な在庫を設定し、実在庫は個別に更新する
+--   UPDATE products SET stock = ... WHERE product_id = ...;
+UPDATE products SET stock = 1000000;
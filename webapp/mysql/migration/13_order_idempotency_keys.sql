@@ -0,0 +1,18 @@
+-- ========================================
+-- 注文作成の冪等キー（Idempotency-Key ヘッダー）
+-- ========================================
+
+-- 同じキーの再送には保存した結果を返し、異なるリクエストでの再利用は拒否する
+CREATE TABLE order_idempotency_keys (
+    user_id INT UNSIGNED NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    checkout_id BIGINT NULL,
+    total_value BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    order_ids MEDIUMTEXT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, idempotency_key),
+    INDEX idx_order_idempotency_keys_expires (expires_at),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,8 @@
+-- ========================================
+-- ロボットのリクエスト署名用の鍵
+-- ========================================
+
+-- APIキーから HKDF で導出した鍵を、サーバーの暗号化鍵（ROBOT_SIGNING_ENCRYPTION_KEY）で暗号化して保存する
+-- key_hash は照合専用とし、署名の鍵には使わない
+-- 既存のキーは平文が残っていないため NULL のままとし、署名付きリクエストにはローテーション後のキーを使う
+ALTER TABLE robot_api_keys ADD COLUMN signing_secret_enc VARBINARY(128) NULL;
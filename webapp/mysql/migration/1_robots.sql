@@ -0,0 +1,20 @@
+-- ========================================
+-- 配送ロボットの登録台帳
+-- ========================================
+
+CREATE TABLE robots (
+    robot_id VARCHAR(64) NOT NULL PRIMARY KEY,
+    api_key VARCHAR(255) NOT NULL,
+    capacity INT UNSIGNED NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'idle',
+    last_seen_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_robots_api_key (api_key)
+);
+
+-- 既存ベンチマーカーが使う共有キーを robot-001 として登録
+INSERT INTO robots (robot_id, api_key, capacity, status) VALUES ('robot-001', 'test-robot-key', 100, 'idle');
+
+-- どのロボットがどの注文を引き受けたかを記録
+ALTER TABLE orders ADD COLUMN robot_id VARCHAR(64) NULL;
+CREATE INDEX idx_orders_robot_status ON orders (robot_id, shipped_status);
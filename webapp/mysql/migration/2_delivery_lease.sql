@@ -0,0 +1,7 @@
+-- ========================================
+-- 配送リース（ロボットの引き受け期限）
+-- ========================================
+
+-- 期限切れの delivering 注文を shipping に戻すために使用
+ALTER TABLE orders ADD COLUMN lease_expires_at DATETIME NULL;
+CREATE INDEX idx_orders_status_lease ON orders (shipped_status, lease_expires_at);
@@ -0,0 +1,19 @@
+-- ========================================
+-- 注文ステータスの遷移履歴
+-- ========================================
+
+CREATE TABLE order_status_history (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    order_id INT UNSIGNED NOT NULL,
+    from_status VARCHAR(50) NULL,
+    to_status VARCHAR(50) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
+
+-- パターン: WHERE order_id = ? ORDER BY id
+CREATE INDEX idx_order_status_history_order ON order_status_history (order_id, id);
+
+-- 旧クライアントが送っていた completed を delivered に統一
+UPDATE orders SET shipped_status = 'delivered' WHERE shipped_status = 'completed';
@@ -0,0 +1,10 @@
+-- ========================================
+-- 多次元の積載制限（重量に加えて容積・個数）
+-- ========================================
+
+-- 商品の容積（0 は容積を消費しない）
+ALTER TABLE products ADD COLUMN volume INT UNSIGNED NOT NULL DEFAULT 0;
+
+-- ロボットの容積上限と積載個数上限（0 は無制限）
+ALTER TABLE robots ADD COLUMN volume_capacity INT UNSIGNED NOT NULL DEFAULT 0;
+ALTER TABLE robots ADD COLUMN max_items INT UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,36 @@
+-- ========================================
+-- 発行した配送計画の履歴（監査用）
+-- ========================================
+
+CREATE TABLE delivery_plans (
+    plan_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    robot_id VARCHAR(64) NOT NULL,
+    capacity INT NOT NULL,
+    volume_capacity INT NOT NULL,
+    max_items INT NOT NULL,
+    objective VARCHAR(20) NOT NULL,
+    solver VARCHAR(20) NOT NULL,
+    exact TINYINT(1) NOT NULL,
+    approximation_ratio DOUBLE NOT NULL,
+    total_weight INT NOT NULL,
+    total_volume INT NOT NULL,
+    total_value INT NOT NULL,
+    order_count INT NOT NULL,
+    candidate_count INT NOT NULL,
+    solve_time_ms BIGINT NOT NULL,
+    lease_expires_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- パターン: WHERE robot_id = ? ORDER BY plan_id DESC
+CREATE INDEX idx_delivery_plans_robot ON delivery_plans (robot_id, plan_id);
+
+CREATE TABLE delivery_plan_orders (
+    plan_id BIGINT NOT NULL,
+    order_id INT UNSIGNED NOT NULL,
+    PRIMARY KEY (plan_id, order_id),
+    FOREIGN KEY (plan_id) REFERENCES delivery_plans(plan_id) ON DELETE CASCADE
+);
+
+-- パターン: WHERE order_id = ?（注文からどの計画で運ばれたかを辿る）
+CREATE INDEX idx_delivery_plan_orders_order ON delivery_plan_orders (order_id);
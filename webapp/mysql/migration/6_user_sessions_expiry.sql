@@ -0,0 +1,5 @@
+-- ========================================
+-- 期限切れセッションの定期削除用インデックス
+-- ========================================
+
+CREATE INDEX idx_user_sessions_expires ON user_sessions (expires_at);
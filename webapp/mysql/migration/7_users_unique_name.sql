@@ -0,0 +1,5 @@
+-- ========================================
+-- ユーザー登録のためユーザー名を一意にする
+-- ========================================
+
+CREATE UNIQUE INDEX uniq_users_user_name ON users (user_name);
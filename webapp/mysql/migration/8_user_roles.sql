@@ -0,0 +1,7 @@
+-- ========================================
+-- ユーザーのロール（customer / operator / admin）
+-- ========================================
+
+-- 既存ユーザーは customer とし、管理者は個別に昇格させる
+--   UPDATE users SET role = 'admin' WHERE user_name = '...';
+ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'customer';
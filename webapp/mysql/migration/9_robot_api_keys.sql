@@ -0,0 +1,23 @@
+-- ========================================
+-- ロボットごとのAPIキー（ハッシュで保存、ローテーション対応）
+-- ========================================
+
+-- key_hash は APIキーの SHA-256（16進）で、キーの照合にのみ使う
+-- （署名の鍵には使わない。署名用の鍵は 14_robot_signing_secrets.sql の signing_secret_enc に暗号化して保存する）
+-- expires_at が NULL のキーは無期限、ローテーション時に旧キーへ猶予期限を設定する
+CREATE TABLE robot_api_keys (
+    key_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    robot_id VARCHAR(64) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    UNIQUE KEY uk_robot_api_keys_hash (key_hash),
+    INDEX idx_robot_api_keys_robot (robot_id),
+    FOREIGN KEY (robot_id) REFERENCES robots(robot_id) ON DELETE CASCADE
+);
+
+-- 既存の平文キーをハッシュ化して移行し、平文の列を削除する
+INSERT INTO robot_api_keys (robot_id, key_hash) SELECT robot_id, SHA2(api_key, 256) FROM robots;
+ALTER TABLE robots DROP INDEX uk_robots_api_key;
+ALTER TABLE robots DROP COLUMN api_key;